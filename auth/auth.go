@@ -0,0 +1,313 @@
+// auth authenticates the callers of endpoints that trigger background work,
+// such as /VerifyQueuedMentions.
+//
+// Callers present either a shared secret or an OIDC ID token, such as the
+// ones Google Cloud Scheduler sends, as a bearer token in the Authorization
+// header.
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GOOGLE_ISSUER is the issuer of the OIDC tokens sent by Google Cloud
+	// Scheduler.
+	GOOGLE_ISSUER = "https://accounts.google.com"
+
+	// keysMaxAge is how long the issuer's signing keys are cached.
+	keysMaxAge = time.Hour
+
+	// refreshInterval is the least time between fetches of the issuer's keys,
+	// so tokens with made up key ids can't make us fetch them on every
+	// request.
+	refreshInterval = time.Minute
+
+	// leeway is the allowed clock skew when checking token times.
+	leeway = time.Minute
+)
+
+// ErrNotConfigured is returned from Authenticate when no form of
+// authentication has been configured, in which case every request is
+// rejected.
+var ErrNotConfigured = errors.New("No authentication configured.")
+
+// Authenticator checks that requests carry a valid shared secret or OIDC
+// token.
+type Authenticator struct {
+	// Secret, if non-empty, is accepted as a bearer token.
+	Secret string
+
+	// OIDC, if non-nil, validates bearer tokens as OIDC ID tokens.
+	OIDC *OIDC
+}
+
+// Authenticate returns nil if the request is from an authorized caller,
+// otherwise it returns an error explaining why the request was rejected.
+func (a *Authenticator) Authenticate(r *http.Request) error {
+	if a.Secret == "" && a.OIDC == nil {
+		return ErrNotConfigured
+	}
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	if a.Secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Secret)) == 1 {
+		return nil
+	}
+	if a.OIDC != nil {
+		return a.OIDC.Verify(token)
+	}
+	return fmt.Errorf("Wrong secret.")
+}
+
+// bearerToken returns the bearer token from the Authorization header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", fmt.Errorf("No Authorization header.")
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+		return "", fmt.Errorf("Authorization header isn't a bearer token.")
+	}
+	return parts[1], nil
+}
+
+// OIDC validates RS256 signed OIDC ID tokens.
+//
+// The issuer's signing keys are found via OpenID Connect Discovery, i.e.
+// Issuer + "/.well-known/openid-configuration", and are cached.
+type OIDC struct {
+	// Issuer must match the token's iss claim, e.g. GOOGLE_ISSUER.
+	Issuer string
+
+	// Audience must match the token's aud claim. For Cloud Scheduler this is
+	// the audience set on the job, by default the URL being called.
+	Audience string
+
+	// Emails, if not empty, restricts tokens to the listed, verified, email
+	// addresses, e.g. the service account used by the Cloud Scheduler job.
+	Emails []string
+
+	// Client is used to fetch the issuer's keys. If nil http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+
+	// refreshed is when the keys were last fetched, or an attempt was made
+	// to.
+	refreshed time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type claims struct {
+	Iss           string      `json:"iss"`
+	Aud           interface{} `json:"aud"`
+	Exp           int64       `json:"exp"`
+	Nbf           int64       `json:"nbf"`
+	Email         string      `json:"email"`
+	EmailVerified bool        `json:"email_verified"`
+}
+
+// audiences returns the aud claim, which may be a single string or a list.
+func (c *claims) audiences() []string {
+	switch aud := c.Aud.(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		ret := []string{}
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// Verify returns nil if token is a valid ID token from the Issuer for the
+// Audience.
+func (o *OIDC) Verify(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("Token is malformed.")
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return fmt.Errorf("Token header is malformed: %s", err)
+	}
+	if h.Alg != "RS256" {
+		return fmt.Errorf("Unsupported token algorithm: %q", h.Alg)
+	}
+	key, err := o.key(h.Kid)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("Token signature is malformed: %s", err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return fmt.Errorf("Token signature is invalid.")
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return fmt.Errorf("Token claims are malformed: %s", err)
+	}
+	if c.Iss != o.Issuer && "https://"+c.Iss != o.Issuer {
+		return fmt.Errorf("Wrong issuer: %q", c.Iss)
+	}
+	audOK := false
+	for _, aud := range c.audiences() {
+		if aud == o.Audience {
+			audOK = true
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("Wrong audience: %v", c.Aud)
+	}
+	now := time.Now()
+	if now.After(time.Unix(c.Exp, 0).Add(leeway)) {
+		return fmt.Errorf("Token expired.")
+	}
+	if c.Nbf != 0 && now.Add(leeway).Before(time.Unix(c.Nbf, 0)) {
+		return fmt.Errorf("Token not valid yet.")
+	}
+	if len(o.Emails) > 0 {
+		if !c.EmailVerified {
+			return fmt.Errorf("Email not verified: %q", c.Email)
+		}
+		for _, email := range o.Emails {
+			if email == c.Email {
+				return nil
+			}
+		}
+		return fmt.Errorf("Email not allowed: %q", c.Email)
+	}
+	return nil
+}
+
+// key returns the issuer's public key with the given id, refreshing the
+// cached keys if they are stale or don't contain kid. The keys are refreshed
+// at most once every refreshInterval, in between a stale key is still used
+// and an unknown key is refused.
+func (o *OIDC) key(kid string) (*rsa.PublicKey, error) {
+	o.mutex.Lock()
+	key, ok := o.keys[kid]
+	if ok && time.Since(o.fetched) < keysMaxAge {
+		o.mutex.Unlock()
+		return key, nil
+	}
+	if time.Since(o.refreshed) < refreshInterval {
+		o.mutex.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("Unknown signing key: %q", kid)
+	}
+	o.refreshed = time.Now()
+	o.mutex.Unlock()
+
+	keys, err := o.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch issuer keys: %s", err)
+	}
+	o.mutex.Lock()
+	o.keys = keys
+	o.fetched = time.Now()
+	o.mutex.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key: %q", kid)
+}
+
+func (o *OIDC) getJSON(url string, dst interface{}) error {
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Not a 200 response from %q: %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// fetchKeys finds the issuer's JWKS via discovery and returns its RSA keys.
+func (o *OIDC) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("No jwks_uri found.")
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	ret := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		ret[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return ret, nil
+}
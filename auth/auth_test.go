@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer is a local OIDC token issuer for testing.
+type issuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// fetches is the number of times the keys were fetched.
+	fetches int32
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	iss := &issuer{
+		key: key,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   iss.server.URL,
+			"jwks_uri": iss.server.URL + "/keys",
+		}))
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&iss.fetches, 1)
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "key1",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		}))
+	})
+	iss.server = httptest.NewServer(mux)
+	return iss
+}
+
+// token returns a signed token with the given claims, with defaults filled in
+// for iss, aud, and exp.
+func (iss *issuer) token(t *testing.T, kid string, c map[string]interface{}) string {
+	all := map[string]interface{}{
+		"iss": iss.server.URL,
+		"aud": "https://webmention.example.com/VerifyQueuedMentions",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range c {
+		all[k] = v
+	}
+	h, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	require.NoError(t, err)
+	b, err := json.Marshal(all)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(b)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// tamper changes one character in the signature of token.
+func tamper(token string) string {
+	b := []byte(token)
+	i := len(b) - 10
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	return string(b)
+}
+
+func request(token string) *http.Request {
+	r := httptest.NewRequest("POST", "/VerifyQueuedMentions", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAuthenticate_NotConfigured(t *testing.T) {
+	a := &Authenticator{}
+	assert.Equal(t, ErrNotConfigured, a.Authenticate(request("anything")))
+}
+
+func TestAuthenticate_Secret(t *testing.T) {
+	a := &Authenticator{Secret: "s3cret"}
+	assert.NoError(t, a.Authenticate(request("s3cret")))
+	assert.Error(t, a.Authenticate(request("wrong")))
+	assert.Error(t, a.Authenticate(request("")))
+
+	r := request("")
+	r.Header.Set("Authorization", "Basic s3cret")
+	assert.Error(t, a.Authenticate(r))
+}
+
+func TestAuthenticate_OIDC(t *testing.T) {
+	iss := newIssuer(t)
+	defer iss.server.Close()
+
+	a := &Authenticator{
+		Secret: "s3cret",
+		OIDC: &OIDC{
+			Issuer:   iss.server.URL,
+			Audience: "https://webmention.example.com/VerifyQueuedMentions",
+			Emails:   []string{"scheduler@example.iam.gserviceaccount.com"},
+		},
+	}
+	good := map[string]interface{}{
+		"email":          "scheduler@example.iam.gserviceaccount.com",
+		"email_verified": true,
+	}
+	assert.NoError(t, a.Authenticate(request(iss.token(t, "key1", good))))
+	assert.NoError(t, a.Authenticate(request("s3cret")))
+
+	tests := map[string]string{
+		"wrong audience":     iss.token(t, "key1", map[string]interface{}{"aud": "https://evil.example.com", "email": "scheduler@example.iam.gserviceaccount.com", "email_verified": true}),
+		"expired":            iss.token(t, "key1", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix(), "email": "scheduler@example.iam.gserviceaccount.com", "email_verified": true}),
+		"wrong issuer":       iss.token(t, "key1", map[string]interface{}{"iss": "https://evil.example.com", "email": "scheduler@example.iam.gserviceaccount.com", "email_verified": true}),
+		"wrong email":        iss.token(t, "key1", map[string]interface{}{"email": "someone@example.com", "email_verified": true}),
+		"unverified email":   iss.token(t, "key1", map[string]interface{}{"email": "scheduler@example.iam.gserviceaccount.com"}),
+		"unknown key":        iss.token(t, "key2", good),
+		"tampered signature": tamper(iss.token(t, "key1", good)),
+		"not a token":        "a.b",
+	}
+	for name, token := range tests {
+		assert.Error(t, a.Authenticate(request(token)), name)
+	}
+}
+
+func TestAuthenticate_OIDCWithListAudience(t *testing.T) {
+	iss := newIssuer(t)
+	defer iss.server.Close()
+
+	a := &Authenticator{
+		OIDC: &OIDC{
+			Issuer:   iss.server.URL,
+			Audience: "https://webmention.example.com/",
+		},
+	}
+	token := iss.token(t, "key1", map[string]interface{}{"aud": []string{"other", "https://webmention.example.com/"}})
+	assert.NoError(t, a.Authenticate(request(token)))
+}
+
+func TestAuthenticate_OIDCUnknownKeysDontRefetch(t *testing.T) {
+	iss := newIssuer(t)
+	defer iss.server.Close()
+
+	a := &Authenticator{
+		OIDC: &OIDC{
+			Issuer:   iss.server.URL,
+			Audience: "https://webmention.example.com/VerifyQueuedMentions",
+		},
+	}
+	for i := 0; i < 5; i++ {
+		assert.Error(t, a.Authenticate(request(iss.token(t, fmt.Sprintf("unknown%d", i), nil))))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&iss.fetches))
+
+	// The keys that were fetched are still used.
+	assert.NoError(t, a.Authenticate(request(iss.token(t, "key1", nil))))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&iss.fetches))
+}
@@ -0,0 +1,305 @@
+// feed parses RSS, Atom, JSON Feed, and h-feed documents into a common list
+// of entries.
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"willnorris.com/go/microformats"
+)
+
+// Entry is a single entry in a feed.
+type Entry struct {
+	// URL is the absolute URL of the entry's page.
+	URL string
+
+	// Updated is when the entry was last updated, or published if it never
+	// was. Zero if the feed doesn't say.
+	Updated time.Time
+
+	// Content is the entry's content as HTML, empty if the feed doesn't
+	// include it.
+	Content string
+}
+
+// Parse parses the feed in b, which was retrieved from baseURL, detecting
+// which of RSS, Atom, JSON Feed, or an HTML page with an h-feed it is.
+// Relative URLs are resolved against baseURL.
+func Parse(b []byte, baseURL string) ([]*Entry, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid feed URL: %s", err)
+	}
+	var entries []*Entry
+	trimmed := bytes.TrimSpace(b)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		entries, err = parseJSONFeed(trimmed)
+	} else {
+		switch rootElement(trimmed) {
+		case "rss":
+			entries, err = parseRSS(trimmed)
+		case "feed":
+			entries, err = parseAtom(trimmed)
+		default:
+			entries = parseHFeed(trimmed, base)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []*Entry{}
+	for _, e := range entries {
+		u, err := url.Parse(strings.TrimSpace(e.URL))
+		if err != nil || e.URL == "" {
+			continue
+		}
+		e.URL = base.ResolveReference(u).String()
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// rootElement returns the local name of the root element of the XML document
+// in b, or "" if b isn't XML.
+func rootElement(b []byte) string {
+	d := newDecoder(b)
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// newDecoder returns an xml.Decoder for b that accepts any declared charset,
+// since feeds are almost always UTF-8 regardless of what they claim.
+func newDecoder(b []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return d
+}
+
+// rssDateFormats are the date formats found in the wild in RSS pubDates.
+var rssDateFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+}
+
+func parseTime(s string, formats ...string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, format := range formats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// latest returns the latest of the times.
+func latest(times ...time.Time) time.Time {
+	ret := time.Time{}
+	for _, t := range times {
+		if t.After(ret) {
+			ret = t
+		}
+	}
+	return ret
+}
+
+type rss struct {
+	Items []struct {
+		Link string `xml:"link"`
+		GUID struct {
+			IsPermaLink string `xml:"isPermaLink,attr"`
+			Value       string `xml:",chardata"`
+		} `xml:"guid"`
+		PubDate     string `xml:"pubDate"`
+		Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Updated     string `xml:"http://www.w3.org/2005/Atom updated"`
+		Description string `xml:"description"`
+		Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	} `xml:"channel>item"`
+}
+
+func parseRSS(b []byte) ([]*Entry, error) {
+	var feed rss
+	if err := newDecoder(b).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("Failed to parse RSS: %s", err)
+	}
+	ret := []*Entry{}
+	for _, item := range feed.Items {
+		link := item.Link
+		if link == "" && item.GUID.IsPermaLink != "false" {
+			link = item.GUID.Value
+		}
+		content := item.Encoded
+		if content == "" {
+			content = item.Description
+		}
+		ret = append(ret, &Entry{
+			URL: link,
+			Updated: latest(
+				parseTime(item.PubDate, rssDateFormats...),
+				parseTime(item.Date, time.RFC3339),
+				parseTime(item.Updated, time.RFC3339),
+			),
+			Content: content,
+		})
+	}
+	return ret, nil
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// html returns the text as HTML.
+func (a *atomText) html() string {
+	switch a.Type {
+	case "html":
+		return a.Text
+	case "xhtml":
+		return a.Inner
+	}
+	return ""
+}
+
+type atom struct {
+	Entries []struct {
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Updated   string   `xml:"updated"`
+		Published string   `xml:"published"`
+		Content   atomText `xml:"content"`
+		Summary   atomText `xml:"summary"`
+	} `xml:"entry"`
+}
+
+func parseAtom(b []byte) ([]*Entry, error) {
+	var feed atom
+	if err := newDecoder(b).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("Failed to parse Atom: %s", err)
+	}
+	ret := []*Entry{}
+	for _, entry := range feed.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		content := entry.Content.html()
+		if content == "" {
+			content = entry.Summary.html()
+		}
+		ret = append(ret, &Entry{
+			URL:     link,
+			Updated: latest(parseTime(entry.Updated, time.RFC3339), parseTime(entry.Published, time.RFC3339)),
+			Content: content,
+		})
+	}
+	return ret, nil
+}
+
+type jsonFeed struct {
+	Items []struct {
+		URL           string `json:"url"`
+		ContentHTML   string `json:"content_html"`
+		DatePublished string `json:"date_published"`
+		DateModified  string `json:"date_modified"`
+	} `json:"items"`
+}
+
+func parseJSONFeed(b []byte) ([]*Entry, error) {
+	var feed jsonFeed
+	if err := json.Unmarshal(b, &feed); err != nil {
+		return nil, fmt.Errorf("Failed to parse JSON Feed: %s", err)
+	}
+	ret := []*Entry{}
+	for _, item := range feed.Items {
+		ret = append(ret, &Entry{
+			URL:     item.URL,
+			Updated: latest(parseTime(item.DatePublished, time.RFC3339), parseTime(item.DateModified, time.RFC3339)),
+			Content: item.ContentHTML,
+		})
+	}
+	return ret, nil
+}
+
+func firstPropAsString(uf *microformats.Microformat, key string) string {
+	for _, sint := range uf.Properties[key] {
+		if s, ok := sint.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// contentHTML returns the HTML of the entry's e-content.
+func contentHTML(uf *microformats.Microformat) string {
+	for _, c := range uf.Properties["content"] {
+		if m, ok := c.(map[string]interface{}); ok {
+			if html, ok := m["html"].(string); ok {
+				return html
+			}
+		}
+	}
+	return ""
+}
+
+func in(s string, arr []string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findHEntries returns all the h-entries in items, looking inside the
+// children of anything that isn't an h-entry, such as an h-feed.
+func findHEntries(items []*microformats.Microformat) []*Entry {
+	ret := []*Entry{}
+	for _, it := range items {
+		if in("h-entry", it.Type) {
+			ret = append(ret, &Entry{
+				URL: firstPropAsString(it, "url"),
+				Updated: latest(
+					parseTime(firstPropAsString(it, "published"), time.RFC3339),
+					parseTime(firstPropAsString(it, "updated"), time.RFC3339),
+				),
+				Content: contentHTML(it),
+			})
+			continue
+		}
+		ret = append(ret, findHEntries(it.Children)...)
+	}
+	return ret
+}
+
+func parseHFeed(b []byte, base *url.URL) []*Entry {
+	return findHEntries(microformats.Parse(bytes.NewReader(b), base).Items)
+}
@@ -0,0 +1,135 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertTime(t *testing.T, expected string, actual time.Time) {
+	e, err := time.Parse(time.RFC3339, expected)
+	require.NoError(t, err)
+	assert.True(t, e.Equal(actual), "%s != %s", expected, actual)
+}
+
+func TestParse_RSS(t *testing.T) {
+	raw := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+  <channel>
+    <title>BitWorking</title>
+    <item>
+      <title>First</title>
+      <link>https://bitworking.org/news/first</link>
+      <pubDate>Wed, 01 May 2019 10:00:00 -0400</pubDate>
+      <description>Summary</description>
+      <content:encoded><![CDATA[<p>See <a href="https://example.com/">this</a>.</p>]]></content:encoded>
+    </item>
+    <item>
+      <title>Second</title>
+      <guid>https://bitworking.org/news/second</guid>
+      <pubDate>Thu, 2 May 2019 10:00:00 GMT</pubDate>
+      <description>&lt;a href="/relative"&gt;link&lt;/a&gt;</description>
+    </item>
+    <item>
+      <title>No link</title>
+      <guid isPermaLink="false">tag:bitworking.org,2019:3</guid>
+    </item>
+  </channel>
+</rss>`
+	entries, err := Parse([]byte(raw), "https://bitworking.org/news/feed/")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://bitworking.org/news/first", entries[0].URL)
+	assertTime(t, "2019-05-01T14:00:00Z", entries[0].Updated)
+	assert.Equal(t, `<p>See <a href="https://example.com/">this</a>.</p>`, entries[0].Content)
+	assert.Equal(t, "https://bitworking.org/news/second", entries[1].URL)
+	assertTime(t, "2019-05-02T10:00:00Z", entries[1].Updated)
+	assert.Equal(t, `<a href="/relative">link</a>`, entries[1].Content)
+}
+
+func TestParse_Atom(t *testing.T) {
+	raw := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>BitWorking</title>
+  <entry>
+    <title>First</title>
+    <link rel="self" href="https://bitworking.org/news/first.atom"/>
+    <link href="https://bitworking.org/news/first"/>
+    <published>2019-05-01T00:00:00Z</published>
+    <updated>2019-05-03T00:00:00Z</updated>
+    <content type="html">&lt;a href="https://example.com/"&gt;link&lt;/a&gt;</content>
+  </entry>
+  <entry>
+    <title>Second</title>
+    <link rel="alternate" href="/news/second"/>
+    <updated>2019-05-02T00:00:00Z</updated>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><a href="https://example.org/">link</a></div></content>
+  </entry>
+</feed>`
+	entries, err := Parse([]byte(raw), "https://bitworking.org/news/feed/")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://bitworking.org/news/first", entries[0].URL)
+	assertTime(t, "2019-05-03T00:00:00Z", entries[0].Updated)
+	assert.Equal(t, `<a href="https://example.com/">link</a>`, entries[0].Content)
+	assert.Equal(t, "https://bitworking.org/news/second", entries[1].URL)
+	assert.Contains(t, entries[1].Content, `href="https://example.org/"`)
+}
+
+func TestParse_JSONFeed(t *testing.T) {
+	raw := `{
+  "version": "https://jsonfeed.org/version/1",
+  "title": "BitWorking",
+  "items": [
+    {
+      "id": "1",
+      "url": "https://bitworking.org/news/first",
+      "content_html": "<a href=\"https://example.com/\">link</a>",
+      "date_published": "2019-05-01T00:00:00Z",
+      "date_modified": "2019-05-04T00:00:00Z"
+    },
+    {
+      "id": "2",
+      "content_text": "No URL."
+    }
+  ]
+}`
+	entries, err := Parse([]byte(raw), "https://bitworking.org/feed.json")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "https://bitworking.org/news/first", entries[0].URL)
+	assertTime(t, "2019-05-04T00:00:00Z", entries[0].Updated)
+	assert.Equal(t, `<a href="https://example.com/">link</a>`, entries[0].Content)
+
+	_, err = Parse([]byte("{ not json"), "https://bitworking.org/feed.json")
+	assert.Error(t, err)
+}
+
+func TestParse_HFeed(t *testing.T) {
+	raw := `<!DOCTYPE html>
+<html>
+<body>
+  <div class="h-feed">
+    <article class="h-entry">
+      <a class="u-url" href="/news/first">First</a>
+      <time class="dt-published" datetime="2019-05-01T00:00:00Z"></time>
+      <div class="e-content"><a href="https://example.com/">link</a></div>
+    </article>
+    <article class="h-entry">
+      <a class="u-url" href="https://bitworking.org/news/second">Second</a>
+    </article>
+  </div>
+</body>
+</html>`
+	entries, err := Parse([]byte(raw), "https://bitworking.org/")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://bitworking.org/news/first", entries[0].URL)
+	assertTime(t, "2019-05-01T00:00:00Z", entries[0].Updated)
+	assert.True(t, strings.Contains(entries[0].Content, `href="https://example.com/"`))
+	assert.Equal(t, "https://bitworking.org/news/second", entries[1].URL)
+	assert.True(t, entries[1].Updated.IsZero())
+}
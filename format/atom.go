@@ -0,0 +1,109 @@
+package format
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// ATOM_CONTENT_TYPE is the Content-Type of an Atom feed.
+const ATOM_CONTENT_TYPE = "application/atom+xml"
+
+// AtomLink is a link in an Atom feed or entry.
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// AtomPerson is the author of an Atom entry.
+type AtomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+// AtomContent is the HTML content of an Atom entry.
+type AtomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// AtomEntry is a mention as an Atom entry.
+type AtomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published,omitempty"`
+	Author    *AtomPerson  `xml:"author"`
+	Links     []*AtomLink  `xml:"link"`
+	Summary   string       `xml:"summary"`
+	Content   *AtomContent `xml:"content"`
+}
+
+// Atom is a list of mentions as an Atom feed.
+type Atom struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Links   []*AtomLink  `xml:"link"`
+	Entries []*AtomEntry `xml:"entry"`
+}
+
+// NewAtomEntry returns m as an Atom entry, where host is where the
+// application is running.
+//
+// The entry links to the page that mentions the target, and is related to
+// the target. It's updated as of when the mention was received.
+func NewAtomEntry(m *mention.Mention, host string) *AtomEntry {
+	ret := &AtomEntry{
+		ID:      ID(m, host),
+		Title:   title(m),
+		Updated: m.TS.UTC().Format(time.RFC3339),
+		Author: &AtomPerson{
+			Name: authorName(m),
+			URI:  m.AuthorURL,
+		},
+		Links: []*AtomLink{
+			{Rel: "alternate", Href: link(m)},
+			{Rel: "related", Href: m.Target},
+		},
+		Summary: summary(m),
+	}
+	if !m.Published.IsZero() {
+		ret.Published = m.Published.UTC().Format(time.RFC3339)
+	}
+	if m.ContentHTML != "" {
+		ret.Content = &AtomContent{
+			Type:  "html",
+			Value: m.ContentHTML,
+		}
+	}
+	return ret
+}
+
+// NewAtom returns the mentions, which should be newest first, as an Atom feed
+// with the given title, whose own URL is selfURL.
+func NewAtom(title, selfURL string, mentions []*mention.Mention, host string) *Atom {
+	ret := &Atom{
+		ID:    selfURL,
+		Title: title,
+		Links: []*AtomLink{
+			{Rel: "self", Href: selfURL},
+			{Rel: "alternate", Href: host},
+		},
+		Entries: []*AtomEntry{},
+	}
+	updated := time.Time{}
+	for _, m := range mentions {
+		if m.TS.After(updated) {
+			updated = m.TS
+		}
+		ret.Entries = append(ret.Entries, NewAtomEntry(m, host))
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	ret.Updated = updated.UTC().Format(time.RFC3339)
+	return ret
+}
@@ -0,0 +1,70 @@
+package format
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/feed"
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+func testMentions() []*mention.Mention {
+	ts := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	return []*mention.Mention{
+		{
+			Source:      "https://example.com/reply",
+			Target:      "https://bitworking.org/news/first",
+			TS:          ts.Add(time.Hour),
+			Title:       "A reply",
+			Author:      "Someone",
+			AuthorURL:   "https://example.com/",
+			Published:   ts,
+			ContentHTML: "<p>I <em>agree</em>.</p>",
+			ContentText: "I agree.",
+		},
+		{
+			Source: "https://brid.gy/like/twitter/1",
+			Target: "https://bitworking.org/news/first",
+			TS:     ts,
+			URL:    "https://twitter.com/someone/status/1",
+			Title:  " Like",
+		},
+	}
+}
+
+func TestNewAtom(t *testing.T) {
+	mentions := testMentions()
+	atom := NewAtom("Webmentions", "https://webmention.bitworking.org/Mentions.atom", mentions, "https://webmention.bitworking.org")
+	assert.Equal(t, "2019-05-01T01:00:00Z", atom.Updated)
+	require.Len(t, atom.Entries, 2)
+
+	reply := atom.Entries[0]
+	assert.Equal(t, "https://webmention.bitworking.org/Status/"+mentions[0].Key(), reply.ID)
+	assert.Equal(t, "A reply", reply.Title)
+	assert.Equal(t, "2019-05-01T00:00:00Z", reply.Published)
+	assert.Equal(t, "Someone", reply.Author.Name)
+	assert.Equal(t, "https://example.com/", reply.Author.URI)
+	assert.Equal(t, "Someone mentioned https://bitworking.org/news/first", reply.Summary)
+	require.NotNil(t, reply.Content)
+	assert.Equal(t, "html", reply.Content.Type)
+	assert.Equal(t, "<p>I <em>agree</em>.</p>", reply.Content.Value)
+
+	like := atom.Entries[1]
+	assert.Equal(t, "brid.gy liked https://bitworking.org/news/first", like.Title)
+	assert.Equal(t, "brid.gy liked https://bitworking.org/news/first", like.Summary)
+	assert.Equal(t, "", like.Published)
+	assert.Nil(t, like.Content)
+
+	b, err := xml.Marshal(atom)
+	require.NoError(t, err)
+	entries, err := feed.Parse(b, "https://webmention.bitworking.org/Mentions.atom")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://example.com/reply", entries[0].URL)
+	assert.Equal(t, "<p>I <em>agree</em>.</p>", entries[0].Content)
+	assert.Equal(t, "https://twitter.com/someone/status/1", entries[1].URL)
+}
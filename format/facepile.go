@@ -0,0 +1,109 @@
+package format
+
+import (
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// Facepile is a group of mentions of the same type that are shown as just
+// their authors' photos.
+type Facepile struct {
+	Singular string
+	Plural   string
+	Mentions []*mention.Mention
+}
+
+// Facepiles is the likes and reposts of a target grouped into facepiles,
+// along with the rest of its mentions.
+type Facepiles struct {
+	// Facepiles holds the likes, then the reposts, leaving out empty ones.
+	Facepiles []*Facepile
+
+	// Comments are all the mentions that aren't in a facepile, e.g. replies,
+	// in the order they were given. Replies to other comments are nested
+	// under them instead.
+	Comments []*Comment
+}
+
+// Comment is a mention shown with its content, along with the replies to it.
+type Comment struct {
+	*mention.Mention
+	Replies []*Comment
+}
+
+// NewFacepiles groups the likes and reposts in mentions into facepiles,
+// leaving the rest as comments. Mentions from before there was a Type are
+// grouped by their titles, see Property.
+func NewFacepiles(mentions []*mention.Mention) *Facepiles {
+	likes := &Facepile{Singular: "like", Plural: "likes"}
+	reposts := &Facepile{Singular: "repost", Plural: "reposts"}
+	ret := &Facepiles{
+		Facepiles: []*Facepile{},
+		Comments:  []*Comment{},
+	}
+	comments := []*Comment{}
+	for _, m := range mentions {
+		switch Property(m) {
+		case LIKE_OF:
+			likes.Mentions = append(likes.Mentions, m)
+		case REPOST_OF:
+			reposts.Mentions = append(reposts.Mentions, m)
+		default:
+			comments = append(comments, &Comment{Mention: m})
+		}
+	}
+	ret.Comments = thread(comments)
+	for _, f := range []*Facepile{likes, reposts} {
+		if len(f.Mentions) > 0 {
+			ret.Facepiles = append(ret.Facepiles, f)
+		}
+	}
+	return ret
+}
+
+// thread nests each comment under the first other comment whose URL or Source
+// it is in reply to, keeping the order they were given, and returns the
+// comments that aren't replies to any other.
+func thread(comments []*Comment) []*Comment {
+	byURL := map[string]*Comment{}
+	for _, c := range comments {
+		for _, u := range []string{c.Source, c.URL} {
+			if u == "" {
+				continue
+			}
+			if _, ok := byURL[mention.Canonicalize(u)]; !ok {
+				byURL[mention.Canonicalize(u)] = c
+			}
+		}
+	}
+	parents := map[*Comment]*Comment{}
+	for _, c := range comments {
+		for _, u := range c.InReplyTo {
+			parent, ok := byURL[mention.Canonicalize(u)]
+			if !ok || parent == c || isAncestor(parents, c, parent) {
+				continue
+			}
+			parents[c] = parent
+			break
+		}
+	}
+	ret := []*Comment{}
+	for _, c := range comments {
+		if parent, ok := parents[c]; ok {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// isAncestor returns true if c is parent or one of parent's ancestors, in
+// which case nesting under parent would make a cycle.
+func isAncestor(parents map[*Comment]*Comment, c, parent *Comment) bool {
+	for p := parent; p != nil; p = parents[p] {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
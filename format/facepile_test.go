@@ -0,0 +1,80 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+func TestNewFacepiles(t *testing.T) {
+	like := &mention.Mention{Source: "https://example.com/like", Type: mention.LIKE_TYPE}
+	legacyLike := &mention.Mention{Source: "https://brid.gy/like/twitter/1", Title: " Like"}
+	repost := &mention.Mention{Source: "https://example.com/repost", Type: mention.REPOST_TYPE}
+	legacyRepost := &mention.Mention{Source: "https://brid.gy/repost/twitter/2", Title: "Twitter Repost"}
+	reply := &mention.Mention{Source: "https://example.com/reply", Type: mention.REPLY_TYPE, Title: "A reply", InReplyTo: []string{"https://bitworking.org/news/post"}}
+	replyToReply := &mention.Mention{Source: "https://example.org/reply", Type: mention.REPLY_TYPE, Title: "Another reply", InReplyTo: []string{"https://bitworking.org/news/post", "http://www.example.com/reply"}}
+	mentioned := &mention.Mention{Source: "https://example.com/post", Title: "A post"}
+
+	facepiles := NewFacepiles([]*mention.Mention{reply, like, repost, legacyLike, mentioned, legacyRepost, replyToReply})
+	require.Len(t, facepiles.Facepiles, 2)
+	assert.Equal(t, "likes", facepiles.Facepiles[0].Plural)
+	assert.Equal(t, []*mention.Mention{like, legacyLike}, facepiles.Facepiles[0].Mentions)
+	assert.Equal(t, "reposts", facepiles.Facepiles[1].Plural)
+	assert.Equal(t, []*mention.Mention{repost, legacyRepost}, facepiles.Facepiles[1].Mentions)
+
+	// Replies, and anything else, are in the order given, with the reply to
+	// the reply nested under it.
+	require.Len(t, facepiles.Comments, 2)
+	assert.Equal(t, reply, facepiles.Comments[0].Mention)
+	require.Len(t, facepiles.Comments[0].Replies, 1)
+	assert.Equal(t, replyToReply, facepiles.Comments[0].Replies[0].Mention)
+	assert.Len(t, facepiles.Comments[0].Replies[0].Replies, 0)
+	assert.Equal(t, mentioned, facepiles.Comments[1].Mention)
+	assert.Len(t, facepiles.Comments[1].Replies, 0)
+}
+
+func TestNewFacepiles_ThreadsRepliesToReplies(t *testing.T) {
+	reply := &mention.Mention{Source: "https://example.com/reply", URL: "https://example.com/2019/reply", InReplyTo: []string{"https://bitworking.org/news/post"}}
+	second := &mention.Mention{Source: "https://example.org/reply", InReplyTo: []string{"https://example.com/2019/reply"}}
+	third := &mention.Mention{Source: "https://example.net/reply", InReplyTo: []string{"https://example.org/reply"}}
+	unrelated := &mention.Mention{Source: "https://example.net/other", InReplyTo: []string{"https://example.net/elsewhere"}}
+
+	// Given newest first, the replies still end up under their parents.
+	facepiles := NewFacepiles([]*mention.Mention{third, second, unrelated, reply})
+	require.Len(t, facepiles.Comments, 2)
+	assert.Equal(t, unrelated, facepiles.Comments[0].Mention)
+	assert.Equal(t, reply, facepiles.Comments[1].Mention)
+	require.Len(t, facepiles.Comments[1].Replies, 1)
+	assert.Equal(t, second, facepiles.Comments[1].Replies[0].Mention)
+	require.Len(t, facepiles.Comments[1].Replies[0].Replies, 1)
+	assert.Equal(t, third, facepiles.Comments[1].Replies[0].Replies[0].Mention)
+}
+
+func TestNewFacepiles_RepliesToEachOtherArentLost(t *testing.T) {
+	a := &mention.Mention{Source: "https://example.com/a", InReplyTo: []string{"https://example.com/b"}}
+	b := &mention.Mention{Source: "https://example.com/b", InReplyTo: []string{"https://example.com/a"}}
+	self := &mention.Mention{Source: "https://example.com/self", InReplyTo: []string{"https://example.com/self"}}
+
+	facepiles := NewFacepiles([]*mention.Mention{a, b, self})
+	require.Len(t, facepiles.Comments, 2)
+	assert.Equal(t, b, facepiles.Comments[0].Mention)
+	require.Len(t, facepiles.Comments[0].Replies, 1)
+	assert.Equal(t, a, facepiles.Comments[0].Replies[0].Mention)
+	assert.Equal(t, self, facepiles.Comments[1].Mention)
+	assert.Len(t, facepiles.Comments[1].Replies, 0)
+}
+
+func TestNewFacepiles_LeavesOutEmpty(t *testing.T) {
+	repost := &mention.Mention{Source: "https://example.com/repost", Type: mention.REPOST_TYPE}
+	facepiles := NewFacepiles([]*mention.Mention{repost})
+	require.Len(t, facepiles.Facepiles, 1)
+	assert.Equal(t, "repost", facepiles.Facepiles[0].Singular)
+	assert.Len(t, facepiles.Comments, 0)
+
+	facepiles = NewFacepiles([]*mention.Mention{})
+	assert.Len(t, facepiles.Facepiles, 0)
+	assert.Len(t, facepiles.Comments, 0)
+}
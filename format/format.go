@@ -0,0 +1,188 @@
+// format converts good webmentions into the structured formats that are
+// served to static site builds and JS widgets.
+package format
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// Mention is the JSON representation of a good webmention.
+type Mention struct {
+	Source    string     `json:"source"`
+	URL       string     `json:"url,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	AuthorURL string     `json:"author_url,omitempty"`
+	Published *time.Time `json:"published,omitempty"`
+
+	// Type is the type of interaction, e.g. "like".
+	Type string `json:"type,omitempty"`
+
+	// Thumbnail is the absolute URL of the author's photo, if any.
+	Thumbnail string `json:"thumbnail,omitempty"`
+
+	// ContentHTML is the sanitized HTML content of the mention, and
+	// ContentText a truncated plain text version of it.
+	ContentHTML string `json:"content_html,omitempty"`
+	ContentText string `json:"content_text,omitempty"`
+
+	// Received is when the webmention was first received.
+	Received time.Time `json:"received"`
+}
+
+// Mentions is a page of the good webmentions of a single target.
+type Mentions struct {
+	Target   string     `json:"target"`
+	Mentions []*Mention `json:"mentions"`
+
+	// Next is the URL of the next page, empty if this is the last one.
+	Next string `json:"next,omitempty"`
+}
+
+// ThumbnailURL returns the absolute URL of the thumbnail with the given id,
+// as served from host, or "" if id is empty.
+func ThumbnailURL(host, id string) string {
+	if id == "" {
+		return ""
+	}
+	return host + "/Thumbnail/" + id
+}
+
+// NewMention returns the JSON representation of m, where host is where the
+// application is running, which serves the thumbnails.
+func NewMention(m *mention.Mention, host string) *Mention {
+	ret := &Mention{
+		Source:      m.Source,
+		URL:         m.URL,
+		Title:       m.Title,
+		Author:      m.Author,
+		AuthorURL:   m.AuthorURL,
+		Type:        m.Type,
+		Thumbnail:   ThumbnailURL(host, m.Thumbnail),
+		ContentHTML: m.ContentHTML,
+		ContentText: m.ContentText,
+		Received:    m.TS.UTC(),
+	}
+	if !m.Published.IsZero() {
+		published := m.Published.UTC()
+		ret.Published = &published
+	}
+	return ret
+}
+
+// NewMentions returns the JSON representation of a page of mentions of
+// target.
+func NewMentions(target string, mentions []*mention.Mention, host string) *Mentions {
+	ret := &Mentions{
+		Target:   target,
+		Mentions: []*Mention{},
+	}
+	for _, m := range mentions {
+		ret.Mentions = append(ret.Mentions, NewMention(m, host))
+	}
+	return ret
+}
+
+// Properties that link a mention to its target, as used by jf2.
+const (
+	MENTION_OF  = "mention-of"
+	IN_REPLY_TO = "in-reply-to"
+	LIKE_OF     = "like-of"
+	REPOST_OF   = "repost-of"
+	BOOKMARK_OF = "bookmark-of"
+	RSVP        = "rsvp"
+)
+
+// properties maps each mention.Mention Type to its property.
+var properties = map[string]string{
+	mention.REPLY_TYPE:    IN_REPLY_TO,
+	mention.LIKE_TYPE:     LIKE_OF,
+	mention.REPOST_TYPE:   REPOST_OF,
+	mention.BOOKMARK_TYPE: BOOKMARK_OF,
+	mention.RSVP_TYPE:     RSVP,
+	mention.MENTION_TYPE:  MENTION_OF,
+}
+
+// interaction returns the property that links m to its target, and m's title.
+//
+// Mentions verified before they had a Type only record likes and reposts as a
+// " Like" or " Repost" suffix on their titles, which is stripped.
+func interaction(m *mention.Mention) (string, string) {
+	if property, ok := properties[m.Type]; ok {
+		return property, m.Title
+	}
+	title := m.Title
+	property := MENTION_OF
+	if strings.HasSuffix(title, " Like") {
+		title = strings.TrimSpace(strings.TrimSuffix(title, " Like"))
+		property = LIKE_OF
+	} else if strings.HasSuffix(title, " Repost") {
+		title = strings.TrimSpace(strings.TrimSuffix(title, " Repost"))
+		property = REPOST_OF
+	}
+	return property, title
+}
+
+// Property returns the property that links m to its target, e.g. LIKE_OF.
+func Property(m *mention.Mention) string {
+	property, _ := interaction(m)
+	return property
+}
+
+// link returns the URL of the page that mentions the target, which is the
+// canonical URL of the h-entry if it has one.
+func link(m *mention.Mention) string {
+	if m.URL != "" {
+		return m.URL
+	}
+	return m.Source
+}
+
+// ID returns the stable ID of m, which is the URL of its status on host.
+func ID(m *mention.Mention, host string) string {
+	return host + "/Status/" + m.Key()
+}
+
+// title returns a title for m, falling back to its summary if m has no
+// title.
+func title(m *mention.Mention) string {
+	_, name := interaction(m)
+	if name != "" {
+		return name
+	}
+	return summary(m)
+}
+
+// authorName returns the author of m, or the host of the source if the
+// author is unknown.
+func authorName(m *mention.Mention) string {
+	if m.Author != "" {
+		return m.Author
+	}
+	if u, err := url.Parse(m.Source); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return m.Source
+}
+
+// summary describes m in a sentence.
+func summary(m *mention.Mention) string {
+	switch property, _ := interaction(m); property {
+	case IN_REPLY_TO:
+		return fmt.Sprintf("%s replied to %s", authorName(m), m.Target)
+	case LIKE_OF:
+		return fmt.Sprintf("%s liked %s", authorName(m), m.Target)
+	case REPOST_OF:
+		return fmt.Sprintf("%s reposted %s", authorName(m), m.Target)
+	case BOOKMARK_OF:
+		return fmt.Sprintf("%s bookmarked %s", authorName(m), m.Target)
+	case RSVP:
+		return fmt.Sprintf("%s RSVPed to %s", authorName(m), m.Target)
+	}
+	return fmt.Sprintf("%s mentioned %s", authorName(m), m.Target)
+}
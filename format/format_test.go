@@ -0,0 +1,95 @@
+package format
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+func TestNewMentions(t *testing.T) {
+	ts := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	mentions := []*mention.Mention{
+		{
+			Source:      "https://example.com/reply",
+			Target:      "https://bitworking.org/news/first",
+			TS:          ts,
+			URL:         "https://example.com/reply#canonical",
+			Title:       "A reply",
+			Author:      "Someone",
+			AuthorURL:   "https://example.com/",
+			Published:   ts.Add(-time.Hour),
+			Thumbnail:   "abc123",
+			ContentHTML: "<p>I <em>agree</em>.</p>",
+			ContentText: "I agree.",
+		},
+		{
+			Source: "https://example.org/like",
+			Target: "https://bitworking.org/news/first",
+			TS:     ts.Add(time.Hour),
+		},
+	}
+	page := NewMentions("https://bitworking.org/news/first", mentions, "https://webmention.bitworking.org")
+	require.Len(t, page.Mentions, 2)
+	assert.Equal(t, "https://webmention.bitworking.org/Thumbnail/abc123", page.Mentions[0].Thumbnail)
+	assert.Equal(t, "", page.Mentions[1].Thumbnail)
+	assert.Nil(t, page.Mentions[1].Published)
+
+	b, err := json.Marshal(page)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"target": "https://bitworking.org/news/first",
+		"mentions": [
+			{
+				"source": "https://example.com/reply",
+				"url": "https://example.com/reply#canonical",
+				"title": "A reply",
+				"author": "Someone",
+				"author_url": "https://example.com/",
+				"published": "2019-04-30T23:00:00Z",
+				"thumbnail": "https://webmention.bitworking.org/Thumbnail/abc123",
+				"content_html": "<p>I <em>agree</em>.</p>",
+				"content_text": "I agree.",
+				"received": "2019-05-01T00:00:00Z"
+			},
+			{
+				"source": "https://example.org/like",
+				"received": "2019-05-01T01:00:00Z"
+			}
+		]
+	}`, string(b))
+}
+
+func TestInteraction(t *testing.T) {
+	for _, tc := range []struct {
+		m        *mention.Mention
+		property string
+		title    string
+	}{
+		{&mention.Mention{Title: "A reply", Type: mention.REPLY_TYPE}, IN_REPLY_TO, "A reply"},
+		{&mention.Mention{Title: "Twitter", Type: mention.LIKE_TYPE}, LIKE_OF, "Twitter"},
+		{&mention.Mention{Title: "Posts I Like", Type: mention.MENTION_TYPE}, MENTION_OF, "Posts I Like"},
+		{&mention.Mention{Title: "Saved", Type: mention.BOOKMARK_TYPE}, BOOKMARK_OF, "Saved"},
+		{&mention.Mention{Title: "Going", Type: mention.RSVP_TYPE}, RSVP, "Going"},
+		{&mention.Mention{Title: "A post", Type: mention.MENTION_TYPE}, MENTION_OF, "A post"},
+
+		// Mentions from before there was a Type.
+		{&mention.Mention{Title: "Twitter Like"}, LIKE_OF, "Twitter"},
+		{&mention.Mention{Title: "Twitter Repost"}, REPOST_OF, "Twitter"},
+		{&mention.Mention{Title: "A post"}, MENTION_OF, "A post"},
+	} {
+		property, title := interaction(tc.m)
+		assert.Equal(t, tc.property, property, tc.m.Title)
+		assert.Equal(t, tc.title, title, tc.m.Title)
+		assert.Equal(t, tc.property, Property(tc.m), tc.m.Title)
+	}
+
+	reply := NewJF2Entry(&mention.Mention{Target: "https://bitworking.org/bar", Type: mention.RSVP_TYPE}, "")
+	assert.Equal(t, "https://bitworking.org/bar", reply.InReplyTo)
+	assert.Equal(t, RSVP, reply.Property)
+	assert.Equal(t, "mention", NewMention(&mention.Mention{Type: mention.MENTION_TYPE}, "").Type)
+}
@@ -0,0 +1,115 @@
+package format
+
+import (
+	"time"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// JF2_CONTENT_TYPE is the Content-Type of a JF2Feed.
+const JF2_CONTENT_TYPE = "application/jf2feed+json"
+
+// JF2Author is the h-card of the author of a mention in jf2.
+type JF2Author struct {
+	Type  string `json:"type"`
+	Name  string `json:"name,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Photo string `json:"photo,omitempty"`
+}
+
+// JF2Content is the content of a mention in jf2.
+type JF2Content struct {
+	HTML string `json:"html,omitempty"`
+	Text string `json:"text"`
+}
+
+// JF2Entry is a mention as a jf2 entry, using the same wm- properties as
+// webmention.io so that existing widgets can read it.
+//
+// The property named by Property is set to the target, except for RSVPs,
+// which are replies, so InReplyTo is set.
+type JF2Entry struct {
+	Type       string      `json:"type"`
+	Author     *JF2Author  `json:"author,omitempty"`
+	URL        string      `json:"url"`
+	Name       string      `json:"name,omitempty"`
+	Published  *time.Time  `json:"published,omitempty"`
+	Content    *JF2Content `json:"content,omitempty"`
+	InReplyTo  string      `json:"in-reply-to,omitempty"`
+	LikeOf     string      `json:"like-of,omitempty"`
+	RepostOf   string      `json:"repost-of,omitempty"`
+	BookmarkOf string      `json:"bookmark-of,omitempty"`
+	MentionOf  string      `json:"mention-of,omitempty"`
+	ID         string      `json:"wm-id"`
+	Source     string      `json:"wm-source"`
+	Target     string      `json:"wm-target"`
+	Property   string      `json:"wm-property"`
+	Received   time.Time   `json:"wm-received"`
+}
+
+// JF2Feed is a list of mentions as a jf2 feed.
+type JF2Feed struct {
+	Type     string      `json:"type"`
+	Name     string      `json:"name"`
+	Children []*JF2Entry `json:"children"`
+}
+
+// NewJF2Entry returns m as a jf2 entry, where host is where the application is
+// running, which serves the thumbnails.
+func NewJF2Entry(m *mention.Mention, host string) *JF2Entry {
+	property, name := interaction(m)
+	ret := &JF2Entry{
+		Type:     "entry",
+		URL:      link(m),
+		Name:     name,
+		ID:       m.Key(),
+		Source:   m.Source,
+		Target:   m.Target,
+		Property: property,
+		Received: m.TS.UTC(),
+	}
+	switch property {
+	case IN_REPLY_TO, RSVP:
+		ret.InReplyTo = m.Target
+	case LIKE_OF:
+		ret.LikeOf = m.Target
+	case REPOST_OF:
+		ret.RepostOf = m.Target
+	case BOOKMARK_OF:
+		ret.BookmarkOf = m.Target
+	default:
+		ret.MentionOf = m.Target
+	}
+	if !m.Published.IsZero() {
+		published := m.Published.UTC()
+		ret.Published = &published
+	}
+	if m.ContentHTML != "" || m.ContentText != "" {
+		ret.Content = &JF2Content{
+			HTML: m.ContentHTML,
+			Text: m.ContentText,
+		}
+	}
+	if m.Author != "" || m.AuthorURL != "" || m.Thumbnail != "" {
+		ret.Author = &JF2Author{
+			Type:  "card",
+			Name:  m.Author,
+			URL:   m.AuthorURL,
+			Photo: ThumbnailURL(host, m.Thumbnail),
+		}
+	}
+	return ret
+}
+
+// NewJF2Feed returns the mentions as a jf2 feed with the given name.
+func NewJF2Feed(name string, mentions []*mention.Mention, host string) *JF2Feed {
+	ret := &JF2Feed{
+		Type:     "feed",
+		Name:     name,
+		Children: []*JF2Entry{},
+	}
+	for _, m := range mentions {
+		ret.Children = append(ret.Children, NewJF2Entry(m, host))
+	}
+	return ret
+}
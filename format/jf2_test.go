@@ -0,0 +1,81 @@
+package format
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+func TestNewJF2Feed(t *testing.T) {
+	ts := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	reply := &mention.Mention{
+		Source:      "https://example.com/reply",
+		Target:      "https://bitworking.org/news/first",
+		TS:          ts,
+		Title:       "A reply",
+		Author:      "Someone",
+		AuthorURL:   "https://example.com/",
+		Published:   ts.Add(-time.Hour),
+		Thumbnail:   "abc123",
+		ContentHTML: "<p>I <em>agree</em>.</p>",
+		ContentText: "I agree.",
+	}
+	like := &mention.Mention{
+		Source: "https://brid.gy/like/twitter/1",
+		Target: "https://bitworking.org/news/first",
+		TS:     ts,
+		URL:    "https://twitter.com/someone/status/1#favorited-by-2",
+		Title:  "Twitter Like",
+	}
+	feed := NewJF2Feed("Webmentions", []*mention.Mention{reply, like}, "https://webmention.bitworking.org")
+	require.Len(t, feed.Children, 2)
+	assert.Equal(t, LIKE_OF, feed.Children[1].Property)
+	assert.Equal(t, "Twitter", feed.Children[1].Name)
+
+	b, err := json.Marshal(feed)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "feed",
+		"name": "Webmentions",
+		"children": [
+			{
+				"type": "entry",
+				"author": {
+					"type": "card",
+					"name": "Someone",
+					"url": "https://example.com/",
+					"photo": "https://webmention.bitworking.org/Thumbnail/abc123"
+				},
+				"url": "https://example.com/reply",
+				"name": "A reply",
+				"published": "2019-04-30T23:00:00Z",
+				"content": {
+					"html": "<p>I <em>agree</em>.</p>",
+					"text": "I agree."
+				},
+				"mention-of": "https://bitworking.org/news/first",
+				"wm-id": "`+reply.Key()+`",
+				"wm-source": "https://example.com/reply",
+				"wm-target": "https://bitworking.org/news/first",
+				"wm-property": "mention-of",
+				"wm-received": "2019-05-01T00:00:00Z"
+			},
+			{
+				"type": "entry",
+				"url": "https://twitter.com/someone/status/1#favorited-by-2",
+				"name": "Twitter",
+				"like-of": "https://bitworking.org/news/first",
+				"wm-id": "`+like.Key()+`",
+				"wm-source": "https://brid.gy/like/twitter/1",
+				"wm-target": "https://bitworking.org/news/first",
+				"wm-property": "like-of",
+				"wm-received": "2019-05-01T00:00:00Z"
+			}
+		]
+	}`, string(b))
+}
@@ -0,0 +1,111 @@
+package format
+
+import (
+	"time"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// JSON_FEED_CONTENT_TYPE is the Content-Type of a JSONFeed.
+const JSON_FEED_CONTENT_TYPE = "application/feed+json"
+
+// JSON_FEED_VERSION is the version of JSON Feed that JSONFeed implements.
+const JSON_FEED_VERSION = "https://jsonfeed.org/version/1.1"
+
+// JSONFeedAuthor is the author of a JSONFeedItem.
+type JSONFeedAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+// JSONFeedWebmention is the extension that records which page was mentioned
+// and how.
+type JSONFeedWebmention struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Property string `json:"property"`
+}
+
+// JSONFeedItem is a mention as a JSON Feed item.
+type JSONFeedItem struct {
+	ID            string              `json:"id"`
+	URL           string              `json:"url"`
+	ExternalURL   string              `json:"external_url"`
+	Title         string              `json:"title,omitempty"`
+	ContentHTML   string              `json:"content_html,omitempty"`
+	ContentText   string              `json:"content_text"`
+	DatePublished time.Time           `json:"date_published"`
+	Authors       []*JSONFeedAuthor   `json:"authors,omitempty"`
+	Webmention    *JSONFeedWebmention `json:"_webmention"`
+}
+
+// JSONFeed is a list of mentions as a JSON Feed.
+type JSONFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url,omitempty"`
+	FeedURL     string          `json:"feed_url,omitempty"`
+	NextURL     string          `json:"next_url,omitempty"`
+	Items       []*JSONFeedItem `json:"items"`
+}
+
+// NewJSONFeedItem returns m as a JSON Feed item, where host is where the
+// application is running, which serves the thumbnails.
+//
+// The item's URL is the page that mentions the target, and its external_url
+// the target. Its content is the mention's content, falling back to the
+// title. Items are dated by when the mention was published, or else
+// received.
+func NewJSONFeedItem(m *mention.Mention, host string) *JSONFeedItem {
+	property, title := interaction(m)
+	ret := &JSONFeedItem{
+		ID:            m.Key(),
+		URL:           link(m),
+		ExternalURL:   m.Target,
+		Title:         title,
+		ContentHTML:   m.ContentHTML,
+		ContentText:   m.ContentText,
+		DatePublished: m.TS.UTC(),
+		Webmention: &JSONFeedWebmention{
+			Source:   m.Source,
+			Target:   m.Target,
+			Property: property,
+		},
+	}
+	if ret.ContentText == "" {
+		ret.ContentText = title
+	}
+	if ret.ContentText == "" {
+		ret.ContentText = link(m)
+	}
+	if !m.Published.IsZero() {
+		ret.DatePublished = m.Published.UTC()
+	}
+	if m.Author != "" || m.AuthorURL != "" || m.Thumbnail != "" {
+		ret.Authors = []*JSONFeedAuthor{
+			{
+				Name:   m.Author,
+				URL:    m.AuthorURL,
+				Avatar: ThumbnailURL(host, m.Thumbnail),
+			},
+		}
+	}
+	return ret
+}
+
+// NewJSONFeed returns the mentions as a JSON Feed with the given title.
+// homePageURL and feedURL are optional.
+func NewJSONFeed(title, homePageURL, feedURL string, mentions []*mention.Mention, host string) *JSONFeed {
+	ret := &JSONFeed{
+		Version:     JSON_FEED_VERSION,
+		Title:       title,
+		HomePageURL: homePageURL,
+		FeedURL:     feedURL,
+		Items:       []*JSONFeedItem{},
+	}
+	for _, m := range mentions {
+		ret.Items = append(ret.Items, NewJSONFeedItem(m, host))
+	}
+	return ret
+}
@@ -0,0 +1,79 @@
+package format
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+func TestNewJSONFeed(t *testing.T) {
+	ts := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	reply := &mention.Mention{
+		Source:      "https://example.com/reply",
+		Target:      "https://bitworking.org/news/first",
+		TS:          ts,
+		Title:       "A reply",
+		Author:      "Someone",
+		AuthorURL:   "https://example.com/",
+		Published:   ts.Add(-time.Hour),
+		Thumbnail:   "abc123",
+		ContentHTML: "<p>I <em>agree</em>.</p>",
+		ContentText: "I agree.",
+	}
+	repost := &mention.Mention{
+		Source: "https://example.org/repost",
+		Target: "https://bitworking.org/news/first",
+		TS:     ts,
+		Title:  " Repost",
+	}
+	feed := NewJSONFeed("Webmentions", "https://bitworking.org/news/first", "", []*mention.Mention{reply, repost}, "https://webmention.bitworking.org")
+	require.Len(t, feed.Items, 2)
+
+	b, err := json.Marshal(feed)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"version": "https://jsonfeed.org/version/1.1",
+		"title": "Webmentions",
+		"home_page_url": "https://bitworking.org/news/first",
+		"items": [
+			{
+				"id": "`+reply.Key()+`",
+				"url": "https://example.com/reply",
+				"external_url": "https://bitworking.org/news/first",
+				"title": "A reply",
+				"content_html": "<p>I <em>agree</em>.</p>",
+				"content_text": "I agree.",
+				"date_published": "2019-04-30T23:00:00Z",
+				"authors": [
+					{
+						"name": "Someone",
+						"url": "https://example.com/",
+						"avatar": "https://webmention.bitworking.org/Thumbnail/abc123"
+					}
+				],
+				"_webmention": {
+					"source": "https://example.com/reply",
+					"target": "https://bitworking.org/news/first",
+					"property": "mention-of"
+				}
+			},
+			{
+				"id": "`+repost.Key()+`",
+				"url": "https://example.org/repost",
+				"external_url": "https://bitworking.org/news/first",
+				"content_text": "https://example.org/repost",
+				"date_published": "2019-05-01T00:00:00Z",
+				"_webmention": {
+					"source": "https://example.org/repost",
+					"target": "https://bitworking.org/news/first",
+					"property": "repost-of"
+				}
+			}
+		]
+	}`, string(b))
+}
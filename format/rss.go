@@ -0,0 +1,83 @@
+package format
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/jcgregorio/webmention-run/mention"
+)
+
+// RSS_CONTENT_TYPE is the Content-Type of an RSS feed.
+const RSS_CONTENT_TYPE = "application/rss+xml"
+
+// RSSGUID is the guid of an RSS item, which is never a permalink since it's
+// the mention's status.
+type RSSGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSSItem is a mention as an RSS item.
+type RSSItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        *RSSGUID `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Description string   `xml:"description"`
+}
+
+// RSSChannel is the channel of an RSS feed.
+type RSSChannel struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Items       []*RSSItem `xml:"item"`
+}
+
+// RSS is a list of mentions as an RSS 2.0 feed.
+type RSS struct {
+	XMLName xml.Name    `xml:"rss"`
+	Version string      `xml:"version,attr"`
+	DC      string      `xml:"xmlns:dc,attr"`
+	Channel *RSSChannel `xml:"channel"`
+}
+
+// NewRSSItem returns m as an RSS item, where host is where the application is
+// running. The item is dated by when the mention was received, and its
+// description is the mention's content, or else a summary of it.
+func NewRSSItem(m *mention.Mention, host string) *RSSItem {
+	ret := &RSSItem{
+		Title: title(m),
+		Link:  link(m),
+		GUID: &RSSGUID{
+			Value: ID(m, host),
+		},
+		PubDate:     m.TS.UTC().Format(time.RFC1123Z),
+		Creator:     authorName(m),
+		Description: summary(m),
+	}
+	if m.ContentHTML != "" {
+		ret.Description = m.ContentHTML
+	}
+	return ret
+}
+
+// NewRSS returns the mentions, which should be newest first, as an RSS feed
+// with the given title.
+func NewRSS(title string, mentions []*mention.Mention, host string) *RSS {
+	ret := &RSS{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: &RSSChannel{
+			Title:       title,
+			Link:        host,
+			Description: title,
+			Items:       []*RSSItem{},
+		},
+	}
+	for _, m := range mentions {
+		ret.Channel.Items = append(ret.Channel.Items, NewRSSItem(m, host))
+	}
+	return ret
+}
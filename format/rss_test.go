@@ -0,0 +1,37 @@
+package format
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcgregorio/webmention-run/feed"
+)
+
+func TestNewRSS(t *testing.T) {
+	mentions := testMentions()
+	rss := NewRSS("Webmentions", mentions, "https://webmention.bitworking.org")
+	require.Len(t, rss.Channel.Items, 2)
+
+	reply := rss.Channel.Items[0]
+	assert.Equal(t, "A reply", reply.Title)
+	assert.Equal(t, "https://example.com/reply", reply.Link)
+	assert.Equal(t, "https://webmention.bitworking.org/Status/"+mentions[0].Key(), reply.GUID.Value)
+	assert.Equal(t, "Wed, 01 May 2019 01:00:00 +0000", reply.PubDate)
+	assert.Equal(t, "Someone", reply.Creator)
+	assert.Equal(t, "<p>I <em>agree</em>.</p>", reply.Description)
+	assert.Equal(t, "brid.gy liked https://bitworking.org/news/first", rss.Channel.Items[1].Description)
+
+	b, err := xml.Marshal(rss)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `<guid isPermaLink="false">`)
+	assert.Contains(t, string(b), `xmlns:dc="http://purl.org/dc/elements/1.1/"`)
+	entries, err := feed.Parse(b, "https://webmention.bitworking.org/Mentions.rss")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "https://example.com/reply", entries[0].URL)
+	assert.Equal(t, "<p>I <em>agree</em>.</p>", entries[0].Content)
+	assert.Equal(t, "https://twitter.com/someone/status/1", entries[1].URL)
+}
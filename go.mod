@@ -1,6 +1,6 @@
 module github.com/jcgregorio/webmention-run
 
-go 1.12
+go 1.13
 
 require (
 	cloud.google.com/go v0.37.2
@@ -10,9 +10,12 @@ require (
 	github.com/jcgregorio/logger v0.0.0-20190327205355-c696fa1d0bdc
 	github.com/jcgregorio/slog v0.0.0-20190327203141-011247cf2291
 	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/lib/pq v1.10.9
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.3.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/net v0.0.0-20190522155817-f3200d17e092
 	google.golang.org/api v0.3.0
 	willnorris.com/go/microformats v1.0.0
 	willnorris.com/go/webmention v0.0.0-20180916134737-ea952590cf48
@@ -0,0 +1,279 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/nfnt/resize"
+	"willnorris.com/go/microformats"
+)
+
+// UrlToPageReader returns the content of the HTML page at url, used to fetch
+// author pages.
+type UrlToPageReader func(ctx context.Context, url string) (io.ReadCloser, error)
+
+// MakeUrlToPageReader returns a UrlToPageReader that fetches pages with the
+// given client, refusing any response whose Content-Type isn't in
+// contentTypes.
+func MakeUrlToPageReader(c *http.Client, contentTypes []string) UrlToPageReader {
+	return func(ctx context.Context, u string) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to build request: %s", err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Error retrieving author page: %s", err)
+		}
+		if resp.StatusCode != 200 {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
+		}
+		if err := contentTypeAllowed(resp.Header.Get("Content-Type"), contentTypes); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+}
+
+// findAuthor fills in the author of the mention from the h-entry it, found in
+// data, following the authorship algorithm:
+//
+//	https://indieweb.org/authorship-spec
+//
+// The author is the h-entry's author property, or else that of the h-feed it
+// is in. An h-card is used as is, a URL is the author page, and any other
+// string is the author's name. Without an author the page's first rel=author
+// link is the author page. The author page is fetched with u2p to find its
+// representative h-card, falling back to an h-card on the source page whose
+// url is the author page, and then to just the author page as AuthorURL.
+func (m *Mentions) findAuthor(ctx context.Context, u2r UrlToImageReader, u2p UrlToPageReader, mention *Mention, data *microformats.Data, it *microformats.Microformat) {
+	author, ok := firstProp(it, "author")
+	if !ok {
+		if feed := parentFeed(data.Items, it); feed != nil {
+			author, ok = firstProp(feed, "author")
+		}
+	}
+	authorPage := ""
+	switch a := author.(type) {
+	case *microformats.Microformat:
+		if in("h-card", a.Type) {
+			relAuthor := ""
+			if len(data.Rels["author"]) > 0 {
+				relAuthor = data.Rels["author"][0]
+			}
+			m.useHCard(ctx, u2r, mention, a, relAuthor)
+			return
+		}
+		mention.Author = a.Value
+		return
+	case string:
+		if !isAbsoluteURL(a) {
+			mention.Author = a
+			return
+		}
+		authorPage = a
+	}
+	if authorPage == "" {
+		if len(data.Rels["author"]) == 0 {
+			return
+		}
+		authorPage = data.Rels["author"][0]
+	}
+	if card := m.authorPageHCard(ctx, u2p, authorPage); card != nil {
+		m.useHCard(ctx, u2r, mention, card, authorPage)
+		return
+	}
+	for _, card := range findHCards(data.Items) {
+		if hasURL(card, authorPage) {
+			m.useHCard(ctx, u2r, mention, card, authorPage)
+			return
+		}
+	}
+	mention.AuthorURL = authorPage
+}
+
+// authorPageHCard returns the representative h-card of the author page, nil
+// if it doesn't have one or can't be fetched.
+func (m *Mentions) authorPageHCard(ctx context.Context, u2p UrlToPageReader, authorPage string) *microformats.Microformat {
+	if u2p == nil {
+		return nil
+	}
+	u, err := url.Parse(authorPage)
+	if err != nil {
+		return nil
+	}
+	r, err := u2p(ctx, authorPage)
+	if err != nil {
+		m.log.Infof("Failed to retrieve author page: %s", err)
+		return nil
+	}
+	defer m.close(r)
+	b, err := readLimited(r, m.MaxSourceBytes)
+	if err != nil {
+		m.log.Infof("Failed to read author page: %s", err)
+		return nil
+	}
+	return representativeHCard(microformats.Parse(bytes.NewReader(b), u), authorPage)
+}
+
+// representativeHCard returns the h-card that represents the page at
+// pageURL, or nil if it has none, following:
+//
+//	http://microformats.org/wiki/representative-h-card-parsing
+func representativeHCard(data *microformats.Data, pageURL string) *microformats.Microformat {
+	cards := findHCards(data.Items)
+	for _, card := range cards {
+		if sameURL(firstPropAsString(card, "uid"), pageURL) && hasURL(card, pageURL) {
+			return card
+		}
+	}
+	for _, card := range cards {
+		for _, me := range data.Rels["me"] {
+			if hasURL(card, me) {
+				return card
+			}
+		}
+	}
+	if len(cards) == 1 && hasURL(cards[0], pageURL) {
+		return cards[0]
+	}
+	return nil
+}
+
+// useHCard fills in the author of the mention from the h-card, using
+// authorURL if the h-card doesn't have a url.
+func (m *Mentions) useHCard(ctx context.Context, u2r UrlToImageReader, mention *Mention, card *microformats.Microformat, authorURL string) {
+	mention.Author = firstPropAsString(card, "name")
+	if mention.Author == "" {
+		mention.Author = card.Value
+	}
+	mention.AuthorURL = firstPropAsString(card, "url")
+	if mention.AuthorURL == "" {
+		mention.AuthorURL = authorURL
+	}
+	if photo := firstPropAsString(card, "photo"); photo != "" {
+		m.findThumbnail(ctx, u2r, mention, photo)
+	} else {
+		m.log.Infof("No photo URL found.")
+	}
+}
+
+// findThumbnail stores a thumbnail of the author's photo found at u.
+func (m *Mentions) findThumbnail(ctx context.Context, u2r UrlToImageReader, mention *Mention, u string) {
+	r, err := u2r(ctx, u)
+	if err != nil {
+		m.log.Infof("Failed to retrieve photo: %s", err)
+		mention.PhotoError = fmt.Sprintf("Failed to retrieve author photo: %s", err)
+		return
+	}
+
+	defer m.close(r)
+	img, err := m.decodePhoto(r)
+	if err != nil {
+		m.log.Infof("Failed to decode photo: %s", err)
+		mention.PhotoError = fmt.Sprintf("Failed to use author photo: %s", err)
+		return
+	}
+	rect := img.Bounds()
+	var x uint = 32
+	var y uint = 32
+	if rect.Max.X > rect.Max.Y {
+		y = 0
+	} else {
+		x = 0
+	}
+	resized := resize.Resize(x, y, img, resize.Lanczos3)
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{
+		CompressionLevel: png.BestCompression,
+	}
+	if err := encoder.Encode(&buf, resized); err != nil {
+		m.log.Errorf("Failed to encode photo.")
+		return
+	}
+
+	hash := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+	t := &Thumbnail{
+		PNG: buf.Bytes(),
+	}
+	if err := m.store.PutThumbnail(ctx, hash, t); err != nil {
+		m.log.Errorf("Failed to write: %s", err)
+		return
+	}
+	mention.Thumbnail = hash
+}
+
+// firstProp returns the first value of the property, and false if it has
+// none.
+func firstProp(uf *microformats.Microformat, key string) (interface{}, bool) {
+	if values := uf.Properties[key]; len(values) > 0 {
+		return values[0], true
+	}
+	return nil, false
+}
+
+// parentFeed returns the h-feed in items that it is a child of, or nil if it
+// isn't in one.
+func parentFeed(items []*microformats.Microformat, it *microformats.Microformat) *microformats.Microformat {
+	for _, item := range items {
+		for _, child := range item.Children {
+			if child == it {
+				if in("h-feed", item.Type) {
+					return item
+				}
+				return nil
+			}
+		}
+		if feed := parentFeed(item.Children, it); feed != nil {
+			return feed
+		}
+	}
+	return nil
+}
+
+// findHCards returns all the top level h-cards in items, along with those in
+// the children of anything that isn't an h-card.
+func findHCards(items []*microformats.Microformat) []*microformats.Microformat {
+	ret := []*microformats.Microformat{}
+	for _, it := range items {
+		if in("h-card", it.Type) {
+			ret = append(ret, it)
+			continue
+		}
+		ret = append(ret, findHCards(it.Children)...)
+	}
+	return ret
+}
+
+// hasURL returns true if any of the h-card's urls are u.
+func hasURL(card *microformats.Microformat, u string) bool {
+	for _, v := range card.Properties["url"] {
+		if s, ok := v.(string); ok && sameURL(s, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURL returns true if a and b are both URLs of the same page.
+func sameURL(a, b string) bool {
+	return a != "" && b != "" && Canonicalize(a) == Canonicalize(b)
+}
+
+// isAbsoluteURL returns true if s is an absolute http or https URL.
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
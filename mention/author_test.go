@@ -0,0 +1,204 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+const authorTestSource = "https://example.com/notes/1"
+
+// parseAuthor finds the author of the h-entry in the source page raw, where
+// pages are the other pages on the web that can be fetched, by URL.
+func parseAuthor(t *testing.T, raw string, pages map[string]string) *Mention {
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	u, err := url.Parse(authorTestSource)
+	assert.NoError(t, err)
+	data := microformats.Parse(strings.NewReader(raw), u)
+	mention := &Mention{
+		Source: authorTestSource,
+	}
+	urlToImageReader := func(ctx context.Context, url string) (io.ReadCloser, error) {
+		return os.Open("./testdata/author_image.jpg")
+	}
+	urlToPageReader := func(ctx context.Context, url string) (io.ReadCloser, error) {
+		page, ok := pages[url]
+		if !ok {
+			return nil, fmt.Errorf("Not a 200 response: 404")
+		}
+		return ioutil.NopCloser(strings.NewReader(page)), nil
+	}
+	m.findHEntry(context.Background(), urlToImageReader, urlToPageReader, mention, data, data.Items)
+	if mention.Thumbnail != "" {
+		assertThumbnail(t, m, mention.Thumbnail)
+	}
+	return mention
+}
+
+func TestFindAuthor_HCard(t *testing.T) {
+	mention := parseAuthor(t, `
+<link rel="author" href="https://example.com/about">
+<article class="h-entry">
+	<div class="p-author h-card">
+		<img class="u-photo" src="/alice.jpg" alt="">
+		<a class="p-name u-url" href="https://alice.example.org/">Alice</a>
+	</div>
+	<p class="e-content">Nice post.</p>
+</article>`, nil)
+	assert.Equal(t, "Alice", mention.Author)
+	// The h-card's own url is preferred over rel=author.
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+	assert.NotEqual(t, "", mention.Thumbnail)
+}
+
+func TestFindAuthor_HCardWithoutURL(t *testing.T) {
+	mention := parseAuthor(t, `
+<link rel="author" href="https://example.com/about">
+<article class="h-entry">
+	<span class="p-author h-card">Alice</span>
+	<p class="e-content">Nice post.</p>
+</article>`, nil)
+	assert.Equal(t, "Alice", mention.Author)
+	assert.Equal(t, "https://example.com/about", mention.AuthorURL)
+	assert.Equal(t, "", mention.Thumbnail)
+}
+
+func TestFindAuthor_Name(t *testing.T) {
+	mention := parseAuthor(t, `
+<article class="h-entry">
+	<span class="p-author">Alice Smith</span>
+	<p class="e-content">Nice post.</p>
+</article>`, nil)
+	assert.Equal(t, "Alice Smith", mention.Author)
+	assert.Equal(t, "", mention.AuthorURL)
+}
+
+func TestFindAuthor_FeedAuthor(t *testing.T) {
+	mention := parseAuthor(t, `
+<div class="h-feed">
+	<a class="p-author h-card" href="https://alice.example.org/">Alice</a>
+	<article class="h-entry">
+		<p class="e-content">Nice post.</p>
+	</article>
+</div>`, nil)
+	assert.Equal(t, "Alice", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+}
+
+func TestFindAuthor_URLWithUIDHCard(t *testing.T) {
+	mention := parseAuthor(t, `
+<article class="h-entry">
+	<a class="u-author" href="https://alice.example.org/"></a>
+	<p class="e-content">Nice post.</p>
+</article>`, map[string]string{
+		"https://alice.example.org/": `
+<div class="h-card">
+	<a class="p-name" href="https://bob.example.org/">Bob, a friend</a>
+</div>
+<div class="h-card">
+	<a class="p-name u-url u-uid" href="/">Alice</a>
+	<img class="u-photo" src="/alice.jpg">
+</div>`,
+	})
+	assert.Equal(t, "Alice", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+	assert.NotEqual(t, "", mention.Thumbnail)
+}
+
+func TestFindAuthor_RelAuthorWithRelMeHCard(t *testing.T) {
+	mention := parseAuthor(t, `
+<link rel="author" href="/about">
+<article class="h-entry">
+	<p class="e-content">Nice post.</p>
+</article>`, map[string]string{
+		"https://example.com/about": `
+<div class="h-card">
+	<span class="p-name">Someone Else</span>
+	<a class="u-url" href="https://else.example.org/">Home</a>
+</div>
+<div class="h-card">
+	<span class="p-name">Alice</span>
+	<a class="u-url" href="https://alice.example.org/">Home</a>
+</div>
+<a rel="me" href="https://alice.example.org/">Me</a>`,
+	})
+	assert.Equal(t, "Alice", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+}
+
+func TestFindAuthor_AuthorPageWithSingleHCard(t *testing.T) {
+	mention := parseAuthor(t, `
+<article class="h-entry">
+	<a class="u-author" href="https://alice.example.org">Alice</a>
+	<p class="e-content">Nice post.</p>
+</article>`, map[string]string{
+		"https://alice.example.org": `
+<div class="h-card">
+	<a class="p-name u-url" href="https://alice.example.org/">Alice Smith</a>
+</div>`,
+	})
+	assert.Equal(t, "Alice Smith", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+}
+
+func TestFindAuthor_HCardOnSourceForAuthorPage(t *testing.T) {
+	// The author page has no representative h-card, so an h-card on the
+	// source page whose url is the author page is used.
+	mention := parseAuthor(t, `
+<link rel="author" href="https://alice.example.org/">
+<article class="h-entry">
+	<p class="e-content">Nice post.</p>
+</article>
+<footer class="h-card">
+	<a class="p-name u-url" href="https://alice.example.org/">Alice</a>
+</footer>`, map[string]string{
+		"https://alice.example.org/": `<p>No microformats here.</p>`,
+	})
+	assert.Equal(t, "Alice", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+}
+
+func TestFindAuthor_AuthorPageOnly(t *testing.T) {
+	// The author page can't be fetched, so only its URL is known.
+	mention := parseAuthor(t, `
+<article class="h-entry">
+	<a class="u-author" href="https://alice.example.org/"></a>
+	<p class="e-content">Nice post.</p>
+</article>`, nil)
+	assert.Equal(t, "", mention.Author)
+	assert.Equal(t, "https://alice.example.org/", mention.AuthorURL)
+}
+
+func TestFindAuthor_None(t *testing.T) {
+	mention := parseAuthor(t, `
+<article class="h-entry">
+	<p class="e-content">Nice post.</p>
+</article>`, nil)
+	assert.Equal(t, "", mention.Author)
+	assert.Equal(t, "", mention.AuthorURL)
+}
+
+func TestReaders_UseContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Requests should not be made once the context is done.")
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := MakeUrlToPageReader(ts.Client(), nil)(ctx, ts.URL)
+	assert.Error(t, err)
+	_, err = MakeUrlToImageReader(ts.Client(), nil)(ctx, ts.URL)
+	assert.Error(t, err)
+}
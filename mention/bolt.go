@@ -0,0 +1,194 @@
+package mention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names, one for each kind of entity stored.
+var (
+	mentionsBucket   = []byte(MENTIONS)
+	sentBucket       = []byte(WEB_MENTION_SENT)
+	thumbnailBucket  = []byte(THUMBNAIL)
+	sentSourceBucket = []byte(WEB_MENTION_SENT_SOURCE)
+)
+
+// BoltStore is a Store that keeps everything in a single local file.
+//
+// Entities are stored as JSON. Queries scan the whole Mentions bucket, which
+// is fine for the number of webmentions a personal site receives.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, creating if necessary, the BoltDB file at filename.
+//
+// Only one process can have the file open at a time, so this fails if
+// another process, such as the running server, already has it open.
+func NewBoltStore(filename string) (*BoltStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("Failed to open %q, it is already open in another process: %s", filename, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %q: %s", filename, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{mentionsBucket, sentBucket, thumbnailBucket, sentSourceBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create buckets: %s", err)
+	}
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+// Close closes the underlying file.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) get(bucket []byte, key string, dst interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, dst)
+	})
+}
+
+func (b *BoltStore) put(bucket []byte, key string, src interface{}) error {
+	v, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), v)
+	})
+}
+
+func (b *BoltStore) GetMention(ctx context.Context, key string) (*Mention, error) {
+	mention := &Mention{}
+	if err := b.get(mentionsBucket, key, mention); err != nil {
+		return nil, err
+	}
+	return mention, nil
+}
+
+func (b *BoltStore) PutMention(ctx context.Context, key string, mention *Mention) error {
+	return b.put(mentionsBucket, key, mention)
+}
+
+func (b *BoltStore) UpdateMention(ctx context.Context, key string, f func(*Mention) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mentionsBucket)
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		var mention Mention
+		if err := json.Unmarshal(v, &mention); err != nil {
+			return err
+		}
+		if err := f(&mention); err != nil {
+			return err
+		}
+		v, err := json.Marshal(&mention)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), v)
+	})
+}
+
+func (b *BoltStore) DeleteMention(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(mentionsBucket).Delete([]byte(key))
+	})
+}
+
+func (b *BoltStore) QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error) {
+	ret := []*MentionWithKey{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(mentionsBucket).ForEach(func(k, v []byte) error {
+			var mention Mention
+			if err := json.Unmarshal(v, &mention); err != nil {
+				return fmt.Errorf("Failed to decode %q: %s", string(k), err)
+			}
+			if q.matches(&mention) {
+				ret = append(ret, &MentionWithKey{
+					Mention: mention,
+					Key:     string(k),
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return []*MentionWithKey{}, err
+	}
+	return q.sortAndPage(ret), nil
+}
+
+func (b *BoltStore) GetThumbnail(ctx context.Context, id string) (*Thumbnail, error) {
+	t := &Thumbnail{}
+	if err := b.get(thumbnailBucket, id, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (b *BoltStore) PutThumbnail(ctx context.Context, id string, t *Thumbnail) error {
+	return b.put(thumbnailBucket, id, t)
+}
+
+func (b *BoltStore) PutSent(ctx context.Context, key string, sent *WebMentionSent) error {
+	return b.put(sentBucket, key, sent)
+}
+
+func (b *BoltStore) QuerySent(ctx context.Context, q *SentQuery) ([]*WebMentionSent, error) {
+	ret := []*WebMentionSent{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sentBucket).ForEach(func(k, v []byte) error {
+			sent := &WebMentionSent{}
+			if err := json.Unmarshal(v, sent); err != nil {
+				return fmt.Errorf("Failed to decode %q: %s", string(k), err)
+			}
+			if q.matches(sent) {
+				ret = append(ret, sent)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return []*WebMentionSent{}, err
+	}
+	return q.sortAndPage(ret), nil
+}
+
+func (b *BoltStore) GetSentSource(ctx context.Context, key string) (*SentSource, error) {
+	s := &SentSource{}
+	if err := b.get(sentSourceBucket, key, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *BoltStore) PutSentSource(ctx context.Context, key string, s *SentSource) error {
+	return b.put(sentSourceBucket, key, s)
+}
+
+// Assert that BoltStore implements Store.
+var _ Store = (*BoltStore)(nil)
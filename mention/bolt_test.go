@@ -0,0 +1,22 @@
+package mention
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewBoltStore(filepath.Join(dir, "webmention.db"))
+	assert.NoError(t, err)
+	defer s.Close()
+
+	testStore(t, s)
+}
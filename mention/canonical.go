@@ -0,0 +1,90 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// INDEX_PAGES are the file names that are dropped from the end of a path when
+// canonicalizing, since servers return the same page without them.
+var INDEX_PAGES = []string{"index.html", "index.htm"}
+
+// Canonicalize returns the canonical form of the URL u, so that all the
+// variants of the URL of a page share the same mentions.
+//
+// The canonical form uses https, a lower case host without a leading "www."
+// or default port, and drops the query, the fragment, any trailing index.html,
+// and any trailing slash. If u can't be parsed, or isn't http or https, it is
+// returned unchanged.
+func Canonicalize(u string) string {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return u
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if (scheme != "http" && scheme != "https") || parsed.Host == "" {
+		return u
+	}
+	host := strings.ToLower(parsed.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if port := parsed.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	path := parsed.EscapedPath()
+	for _, index := range INDEX_PAGES {
+		if strings.HasSuffix(path, "/"+index) {
+			path = strings.TrimSuffix(path, index)
+			break
+		}
+	}
+	path = strings.TrimRight(path, "/")
+	return "https://" + host + path
+}
+
+// CanonicalizeTargets rewrites the Target of every stored mention that was
+// received before targets were canonicalized, returning how many were
+// rewritten. It is safe to run again, and does nothing once every Target is
+// canonical.
+//
+// Since the key of a mention is derived from its Target, each one is moved to
+// its new key. If the mention was re-sent after canonicalization there is
+// already a mention at the new key, in which case the moderation decision of
+// the original is kept, and the mention is queued to be verified again to
+// pick up the newer content.
+func (m *Mentions) CanonicalizeTargets(ctx context.Context) (int, error) {
+	all, err := m.store.QueryMentions(ctx, &Query{})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read mentions: %s", err)
+	}
+	n := 0
+	for _, old := range all {
+		target := Canonicalize(old.Target)
+		if target == old.Target {
+			continue
+		}
+		mention := old.Mention
+		mention.Target = target
+		existing, err := m.store.GetMention(ctx, mention.Key())
+		if err == nil {
+			if old.State == UNTRIAGED_STATE {
+				mention = *existing
+			} else {
+				mention.Reverify = true
+				mention.Updated = existing.TS
+			}
+		} else if err != ErrNotFound {
+			return n, fmt.Errorf("Failed to read %q: %s", mention.Key(), err)
+		}
+		if err := m.store.PutMention(ctx, mention.Key(), &mention); err != nil {
+			return n, fmt.Errorf("Failed to write %q: %s", mention.Key(), err)
+		}
+		if err := m.store.DeleteMention(ctx, old.Key); err != nil {
+			return n, fmt.Errorf("Failed to remove %q: %s", old.Key, err)
+		}
+		n++
+	}
+	return n, nil
+}
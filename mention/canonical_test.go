@@ -0,0 +1,115 @@
+package mention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalize(t *testing.T) {
+	canonical := "https://bitworking.org/news/2019/01/some-post"
+	for _, u := range []string{
+		"https://bitworking.org/news/2019/01/some-post",
+		"https://bitworking.org/news/2019/01/some-post/",
+		"http://bitworking.org/news/2019/01/some-post",
+		"https://www.bitworking.org/news/2019/01/some-post",
+		"https://BitWorking.org/news/2019/01/some-post",
+		"https://bitworking.org:443/news/2019/01/some-post",
+		"https://bitworking.org/news/2019/01/some-post?utm_source=feed",
+		"https://bitworking.org/news/2019/01/some-post#comments",
+		"https://bitworking.org/news/2019/01/some-post/index.html",
+		"HTTP://www.bitworking.org/news/2019/01/some-post/index.htm?a=b#c",
+		" https://bitworking.org/news/2019/01/some-post ",
+	} {
+		assert.Equal(t, canonical, Canonicalize(u), u)
+	}
+
+	assert.Equal(t, "https://bitworking.org", Canonicalize("https://bitworking.org/"))
+	assert.Equal(t, "https://bitworking.org", Canonicalize("http://www.bitworking.org/index.html"))
+	assert.Equal(t, "https://bitworking.org:8080/a", Canonicalize("http://bitworking.org:8080/a"))
+	assert.Equal(t, "https://bitworking.org/a%20b", Canonicalize("https://bitworking.org/a%20b/"))
+	assert.Equal(t, "https://bitworking.org/News", Canonicalize("https://bitworking.org/News"))
+
+	// Things that aren't http or https URLs are left alone.
+	assert.Equal(t, "mailto:joe@bitworking.org", Canonicalize("mailto:joe@bitworking.org"))
+	assert.Equal(t, "/news/relative", Canonicalize("/news/relative"))
+	assert.Equal(t, "", Canonicalize(""))
+}
+
+func TestSlowValidate_LinkToVariantOfTarget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<a href="http://www.bitworking.org/bar/#comments">link</a>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	mention := New(ts.URL+"/reply", "https://bitworking.org/bar")
+	assert.NoError(t, m.SlowValidate(context.Background(), mention, ts.Client()))
+
+	mention = New(ts.URL+"/reply", "https://bitworking.org/baz")
+	assert.Error(t, m.SlowValidate(context.Background(), mention, ts.Client()))
+}
+
+func TestGetGood_VariantsOfTarget(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		mention := New("https://example.com/reply", Canonicalize("https://www.bitworking.org/bar/"))
+		mention.State = GOOD_STATE
+		assert.NoError(t, m.store.PutMention(ctx, mention.Key(), mention))
+
+		for _, target := range []string{
+			"https://bitworking.org/bar",
+			"https://bitworking.org/bar/",
+			"http://bitworking.org/bar?ref=home",
+			"https://www.bitworking.org/bar/index.html",
+		} {
+			assert.Len(t, m.GetGood(ctx, target), 1, target)
+		}
+	})
+}
+
+func TestCanonicalizeTargets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		good := New("https://example.com/good", "http://www.bitworking.org/bar/")
+		good.State = GOOD_STATE
+		spam := New("https://example.com/spam", "https://bitworking.org/bar/")
+		spam.State = SPAM_STATE
+		// The spam was re-sent after canonicalization, and verified.
+		resent := New("https://example.com/spam", "https://bitworking.org/bar")
+		resent.State = GOOD_STATE
+		for _, mention := range []*Mention{good, spam, resent} {
+			assert.NoError(t, m.Put(ctx, mention))
+		}
+
+		n, err := m.CanonicalizeTargets(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 2)
+		byKey := map[string]*Mention{}
+		for _, mention := range all {
+			assert.Equal(t, "https://bitworking.org/bar", mention.Target)
+			byKey[mention.Key()] = mention
+		}
+		assert.Equal(t, GOOD_STATE, byKey[New(good.Source, "https://bitworking.org/bar").Key()].State)
+		moved := byKey[resent.Key()]
+		assert.Equal(t, SPAM_STATE, moved.State)
+		assert.True(t, moved.Reverify)
+
+		_, err = m.store.GetMention(ctx, good.Key())
+		assert.Equal(t, ErrNotFound, err)
+
+		// Running it again does nothing.
+		n, err = m.CanonicalizeTargets(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+}
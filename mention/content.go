@@ -0,0 +1,210 @@
+package mention
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"willnorris.com/go/microformats"
+)
+
+// Limits on the content stored with a mention.
+const (
+	// CONTENT_TEXT_LENGTH is the maximum number of characters kept in
+	// ContentText.
+	CONTENT_TEXT_LENGTH = 500
+
+	// MAX_CONTENT_HTML_BYTES is the largest ContentHTML that is kept. Longer
+	// content is dropped, leaving only ContentText, so that a mention never
+	// gets too large to store.
+	MAX_CONTENT_HTML_BYTES = 64 << 10
+)
+
+// ALLOWED_TAGS are the elements kept by SanitizeHTML, along with the
+// attributes kept on each.
+var ALLOWED_TAGS = map[atom.Atom][]string{
+	atom.A:          {"href"},
+	atom.Abbr:       {"title"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Cite:       nil,
+	atom.Code:       nil,
+	atom.Del:        nil,
+	atom.Em:         nil,
+	atom.I:          nil,
+	atom.Ins:        nil,
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Q:          nil,
+	atom.S:          nil,
+	atom.Small:      nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Ul:         nil,
+}
+
+// DROPPED_TAGS are the elements that SanitizeHTML removes along with all of
+// their content. Any other element that isn't allowed is replaced with its
+// content.
+var DROPPED_TAGS = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Template: true,
+	atom.Noscript: true,
+	atom.Svg:      true,
+	atom.Math:     true,
+	atom.Form:     true,
+	atom.Textarea: true,
+	atom.Select:   true,
+	atom.Head:     true,
+	atom.Title:    true,
+}
+
+// SanitizeHTML returns the HTML fragment s with only the ALLOWED_TAGS and
+// their attributes. Relative links are resolved against base, links that
+// aren't http, https, or mailto are removed, and the remaining links get
+// rel="nofollow ugc".
+func SanitizeHTML(s string, base *url.URL) string {
+	context := &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	}
+	nodes, err := nethtml.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		return html.EscapeString(s)
+	}
+	var b bytes.Buffer
+	for _, n := range nodes {
+		sanitizeNode(&b, n, base)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func sanitizeNode(b *bytes.Buffer, n *nethtml.Node, base *url.URL) {
+	switch n.Type {
+	case nethtml.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case nethtml.ElementNode:
+	default:
+		return
+	}
+	if DROPPED_TAGS[n.DataAtom] {
+		return
+	}
+	allowed, ok := ALLOWED_TAGS[n.DataAtom]
+	if !ok {
+		sanitizeChildren(b, n, base)
+		return
+	}
+	b.WriteString("<" + n.Data)
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" || !in(attr.Key, allowed) {
+			continue
+		}
+		value := attr.Val
+		if attr.Key == "href" {
+			value = safeLink(value, base)
+			if value == "" {
+				continue
+			}
+		}
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(value) + `"`)
+	}
+	if n.DataAtom == atom.A {
+		b.WriteString(` rel="nofollow ugc"`)
+	}
+	b.WriteString(">")
+	if n.DataAtom == atom.Br {
+		return
+	}
+	sanitizeChildren(b, n, base)
+	b.WriteString("</" + n.Data + ">")
+}
+
+func sanitizeChildren(b *bytes.Buffer, n *nethtml.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sanitizeNode(b, c, base)
+	}
+}
+
+// safeLink returns link resolved against base, or "" if it isn't an http,
+// https, or mailto URL.
+func safeLink(link string, base *url.URL) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return u.String()
+	}
+	return ""
+}
+
+// TruncateText collapses all the runs of white space in s into single spaces
+// and then truncates it to at most max characters, ending in an ellipsis if
+// anything was cut off.
+func TruncateText(s string, max int) string {
+	s = strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	cut := strings.TrimRightFunc(string(runes[:max-1]), unicode.IsSpace)
+	return cut + "…"
+}
+
+// findContent returns the content of the h-entry as sanitized HTML and as
+// truncated plain text, taken from the first of e-content, summary, or name
+// that it has. Relative links are resolved against the source URL.
+func findContent(it *microformats.Microformat, source string) (string, string) {
+	contentHTML := ""
+	text := ""
+	for _, c := range it.Properties["content"] {
+		if m, ok := c.(map[string]interface{}); ok {
+			contentHTML, _ = m["html"].(string)
+			text, _ = m["value"].(string)
+			break
+		}
+		if s, ok := c.(string); ok {
+			text = s
+			break
+		}
+	}
+	if contentHTML == "" && text == "" {
+		text = firstPropAsString(it, "summary")
+	}
+	if contentHTML == "" && text == "" {
+		text = firstPropAsString(it, "name")
+	}
+	text = strings.TrimSpace(text)
+	if contentHTML != "" {
+		base, err := url.Parse(source)
+		if err != nil {
+			base = nil
+		}
+		contentHTML = SanitizeHTML(contentHTML, base)
+	} else if text != "" {
+		contentHTML = html.EscapeString(text)
+	}
+	if len(contentHTML) > MAX_CONTENT_HTML_BYTES {
+		contentHTML = ""
+	}
+	return contentHTML, TruncateText(text, CONTENT_TEXT_LENGTH)
+}
@@ -0,0 +1,80 @@
+package mention
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	base, err := url.Parse("https://example.com/notes/1")
+	assert.NoError(t, err)
+	for raw, expected := range map[string]string{
+		`<p>Great <em>post</em>!</p>`:                               `<p>Great <em>post</em>!</p>`,
+		`<p onclick="alert(1)" class="x">Hi</p>`:                    `<p>Hi</p>`,
+		`Hi<script>alert(1)</script> there`:                         `Hi there`,
+		`<style>p {}</style><iframe src="x">y</iframe>ok`:           `ok`,
+		`<div><span>unwrapped</span></div>`:                         `unwrapped`,
+		`<a href="/other">link</a>`:                                 `<a href="https://example.com/other" rel="nofollow ugc">link</a>`,
+		`<a href="javascript:alert(1)">link</a>`:                    `<a rel="nofollow ugc">link</a>`,
+		`<a href="mailto:me@example.com" rel="me">mail</a>`:         `<a href="mailto:me@example.com" rel="nofollow ugc">mail</a>`,
+		`<img src="x" onerror="alert(1)">line<br>break`:             `line<br>break`,
+		`<!-- comment -->a &lt;b&gt; &amp; c`:                       `a &lt;b&gt; &amp; c`,
+		`<blockquote><p>quoted</p></blockquote><ul><li>x</li></ul>`: `<blockquote><p>quoted</p></blockquote><ul><li>x</li></ul>`,
+	} {
+		assert.Equal(t, expected, SanitizeHTML(raw, base), raw)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	assert.Equal(t, "", TruncateText("", 10))
+	assert.Equal(t, "a b c", TruncateText("  a\n\tb   c ", 10))
+	assert.Equal(t, "0123456789", TruncateText("0123456789", 10))
+	assert.Equal(t, "012345678…", TruncateText("01234567890", 10))
+	assert.Equal(t, "one two…", TruncateText("one two three", 9))
+	assert.Equal(t, "ééééééééé…", TruncateText(strings.Repeat("é", 20), 10))
+}
+
+func TestFindContent(t *testing.T) {
+	source := "https://example.com/notes/1"
+	u, err := url.Parse(source)
+	assert.NoError(t, err)
+	parse := func(body string) *microformats.Microformat {
+		data := microformats.Parse(strings.NewReader(`<div class="h-entry">`+body+`</div>`), u)
+		assert.Len(t, data.Items, 1)
+		return data.Items[0]
+	}
+
+	// e-content is preferred, and is sanitized.
+	contentHTML, text := findContent(parse(`
+		<span class="p-name">A title</span>
+		<p class="p-summary">A summary</p>
+		<div class="e-content">I <b>agree</b>, see <a href="/2">this</a>.<script>alert(1)</script></div>`), source)
+	assert.Equal(t, `I <b>agree</b>, see <a href="https://example.com/2" rel="nofollow ugc">this</a>.`, contentHTML)
+	assert.Equal(t, "I agree, see this.", text)
+
+	// Then the summary.
+	contentHTML, text = findContent(parse(`
+		<span class="p-name">A title</span>
+		<p class="p-summary">A summary &amp; &lt;more&gt;</p>`), source)
+	assert.Equal(t, "A summary &amp; &lt;more&gt;", contentHTML)
+	assert.Equal(t, "A summary & <more>", text)
+
+	// Then the name.
+	contentHTML, text = findContent(parse(`<span class="p-name">A title</span>`), source)
+	assert.Equal(t, "A title", contentHTML)
+	assert.Equal(t, "A title", text)
+
+	// Long content is truncated as text.
+	_, text = findContent(parse(`<div class="e-content">`+strings.Repeat("word ", 200)+`</div>`), source)
+	assert.Len(t, []rune(text), CONTENT_TEXT_LENGTH)
+	assert.True(t, strings.HasSuffix(text, "…"))
+
+	// Content too large to store only keeps the text.
+	contentHTML, text = findContent(parse(`<div class="e-content">`+strings.Repeat("<p>word</p>", MAX_CONTENT_HTML_BYTES/10)+`</div>`), source)
+	assert.Equal(t, "", contentHTML)
+	assert.NotEqual(t, "", text)
+}
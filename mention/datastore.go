@@ -0,0 +1,207 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+)
+
+const (
+	MENTIONS                ds.Kind = "Mentions"
+	WEB_MENTION_SENT        ds.Kind = "WebMentionSent"
+	THUMBNAIL               ds.Kind = "Thumbnail"
+	WEB_MENTION_SENT_SOURCE ds.Kind = "WebMentionSentSource"
+)
+
+// DatastoreStore is a Store backed by Google Cloud Datastore.
+type DatastoreStore struct {
+	DS *ds.DS
+}
+
+// NewDatastoreStore creates a new DatastoreStore.
+//
+// project - The Google Cloud project name.
+// ns      - The datastore namespace to store data into.
+func NewDatastoreStore(ctx context.Context, project, ns string) (*DatastoreStore, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &DatastoreStore{
+		DS: d,
+	}, nil
+}
+
+func (d *DatastoreStore) key(kind ds.Kind, name string) *datastore.Key {
+	key := d.DS.NewKey(kind)
+	key.Name = name
+	return key
+}
+
+func (d *DatastoreStore) get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	err := d.DS.Client.Get(ctx, key, dst)
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (d *DatastoreStore) GetMention(ctx context.Context, key string) (*Mention, error) {
+	mention := &Mention{}
+	if err := d.get(ctx, d.key(MENTIONS, key), mention); err != nil {
+		return nil, err
+	}
+	return mention, nil
+}
+
+func (d *DatastoreStore) PutMention(ctx context.Context, key string, mention *Mention) error {
+	_, err := d.DS.Client.Put(ctx, d.key(MENTIONS, key), mention)
+	return err
+}
+
+func (d *DatastoreStore) UpdateMention(ctx context.Context, key string, f func(*Mention) error) error {
+	dsKey := d.key(MENTIONS, key)
+	_, err := d.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var mention Mention
+		if err := tx.Get(dsKey, &mention); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return ErrNotFound
+			}
+			return fmt.Errorf("tx.Get: %v", err)
+		}
+		if err := f(&mention); err != nil {
+			return err
+		}
+		if _, err := tx.Put(dsKey, &mention); err != nil {
+			return fmt.Errorf("tx.Put: %v", err)
+		}
+		return nil
+	})
+	return err
+}
+
+func (d *DatastoreStore) DeleteMention(ctx context.Context, key string) error {
+	return d.DS.Client.Delete(ctx, d.key(MENTIONS, key))
+}
+
+// QueryMentions implements Store.
+//
+// Filtered queries are sorted and paged in memory so that no composite
+// indexes need to be created.
+func (d *DatastoreStore) QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error) {
+	ret := []*MentionWithKey{}
+	dq := d.DS.NewQuery(MENTIONS)
+	filtered := false
+	if q.Target != "" {
+		dq = dq.Filter("Target =", q.Target)
+		filtered = true
+	}
+	if q.State != "" {
+		dq = dq.Filter("State =", q.State)
+		filtered = true
+	}
+	if q.Reverify {
+		dq = dq.Filter("Reverify =", true)
+		filtered = true
+	}
+	if q.Type != "" {
+		dq = dq.Filter("Type =", q.Type)
+		filtered = true
+	}
+	if !filtered {
+		if q.NewestFirst {
+			dq = dq.Order("-TS")
+		} else {
+			dq = dq.Order("TS")
+		}
+		if q.Limit > 0 {
+			dq = dq.Limit(q.Limit)
+		}
+		dq = dq.Offset(q.Offset)
+	}
+
+	it := d.DS.Client.Run(ctx, dq)
+	for {
+		var mention Mention
+		key, err := it.Next(&mention)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		ret = append(ret, &MentionWithKey{
+			Mention: mention,
+			Key:     key.Name,
+		})
+	}
+	if filtered {
+		ret = q.sortAndPage(ret)
+	}
+	return ret, nil
+}
+
+func (d *DatastoreStore) GetThumbnail(ctx context.Context, id string) (*Thumbnail, error) {
+	t := &Thumbnail{}
+	if err := d.get(ctx, d.key(THUMBNAIL, id), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (d *DatastoreStore) PutThumbnail(ctx context.Context, id string, t *Thumbnail) error {
+	_, err := d.DS.Client.Put(ctx, d.key(THUMBNAIL, id), t)
+	return err
+}
+
+func (d *DatastoreStore) PutSent(ctx context.Context, key string, sent *WebMentionSent) error {
+	_, err := d.DS.Client.Put(ctx, d.key(WEB_MENTION_SENT, key), sent)
+	return err
+}
+
+// QuerySent implements Store.
+//
+// There are few enough sent records that they are always filtered, sorted,
+// and paged in memory.
+func (d *DatastoreStore) QuerySent(ctx context.Context, q *SentQuery) ([]*WebMentionSent, error) {
+	ret := []*WebMentionSent{}
+	dq := d.DS.NewQuery(WEB_MENTION_SENT)
+	if q.Source != "" {
+		dq = dq.Filter("Source =", q.Source)
+	}
+	it := d.DS.Client.Run(ctx, dq)
+	for {
+		sent := &WebMentionSent{}
+		_, err := it.Next(sent)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		if q.matches(sent) {
+			ret = append(ret, sent)
+		}
+	}
+	return q.sortAndPage(ret), nil
+}
+
+func (d *DatastoreStore) GetSentSource(ctx context.Context, key string) (*SentSource, error) {
+	s := &SentSource{}
+	if err := d.get(ctx, d.key(WEB_MENTION_SENT_SOURCE, key), s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *DatastoreStore) PutSentSource(ctx context.Context, key string, s *SentSource) error {
+	_, err := d.DS.Client.Put(ctx, d.key(WEB_MENTION_SENT_SOURCE, key), s)
+	return err
+}
+
+// Assert that DatastoreStore implements Store.
+var _ Store = (*DatastoreStore)(nil)
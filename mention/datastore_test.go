@@ -0,0 +1,14 @@
+package mention
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDatastoreStore(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST not set, see `make start_datastore_emulator`.")
+	}
+	m := InitForTesting(t)
+	testStore(t, m.store)
+}
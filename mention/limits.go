@@ -0,0 +1,96 @@
+package mention
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+)
+
+// Default limits on fetched sources and author photos.
+const (
+	DEFAULT_MAX_SOURCE_BYTES = 5 << 20
+	DEFAULT_MAX_PHOTO_BYTES  = 2 << 20
+	DEFAULT_MAX_PHOTO_PIXELS = 4096 * 4096
+)
+
+var (
+	// DEFAULT_SOURCE_CONTENT_TYPES are the content types of sources that are
+	// searched for links.
+	DEFAULT_SOURCE_CONTENT_TYPES = []string{"text/html", "application/xhtml+xml"}
+
+	// DEFAULT_PHOTO_CONTENT_TYPES are the content types of author photos that
+	// are turned into thumbnails.
+	DEFAULT_PHOTO_CONTENT_TYPES = []string{"image/png", "image/jpeg", "image/gif"}
+)
+
+// contentTypeAllowed returns an error if the media type of the Content-Type
+// header value isn't in allowed. A missing Content-Type, or an empty allowed
+// list, is always allowed since the content is parsed strictly anyway.
+func contentTypeAllowed(header string, allowed []string) error {
+	if header == "" || len(allowed) == 0 {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return fmt.Errorf("Invalid content type %q: %s", header, err)
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(mediaType, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Content type %q not allowed.", mediaType)
+}
+
+// limitError is returned from readLimited when the limit is exceeded.
+type limitError struct {
+	max int64
+}
+
+func (l *limitError) Error() string {
+	return fmt.Sprintf("Larger than the limit of %d bytes.", l.max)
+}
+
+// readLimited reads all of r, returning a *limitError if it is longer than
+// max bytes. A max of 0 or less means no limit.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, &limitError{max: max}
+	}
+	return b, nil
+}
+
+// decodePhoto decodes the image in r, checking the size in bytes, and the
+// dimensions from the image header, before doing the full decode.
+func (m *Mentions) decodePhoto(r io.Reader) (image.Image, error) {
+	b, err := readLimited(r, m.MaxPhotoBytes)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode photo header: %s", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("Invalid photo dimensions: %dx%d", config.Width, config.Height)
+	}
+	if m.MaxPhotoPixels > 0 && int64(config.Width)*int64(config.Height) > m.MaxPhotoPixels {
+		return nil, fmt.Errorf("Photo dimensions %dx%d exceed the limit of %d pixels.", config.Width, config.Height, m.MaxPhotoPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode photo: %s", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,153 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentTypeAllowed(t *testing.T) {
+	assert.NoError(t, contentTypeAllowed("text/html; charset=utf-8", DEFAULT_SOURCE_CONTENT_TYPES))
+	assert.NoError(t, contentTypeAllowed("TEXT/HTML", DEFAULT_SOURCE_CONTENT_TYPES))
+	assert.NoError(t, contentTypeAllowed("", DEFAULT_SOURCE_CONTENT_TYPES))
+	assert.NoError(t, contentTypeAllowed("application/octet-stream", nil))
+	assert.Error(t, contentTypeAllowed("application/octet-stream", DEFAULT_SOURCE_CONTENT_TYPES))
+	assert.Error(t, contentTypeAllowed("image/svg+xml", DEFAULT_PHOTO_CONTENT_TYPES))
+	assert.Error(t, contentTypeAllowed("not a; = content type", DEFAULT_SOURCE_CONTENT_TYPES))
+}
+
+func TestReadLimited(t *testing.T) {
+	b, err := readLimited(strings.NewReader("12345"), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(b))
+
+	_, err = readLimited(strings.NewReader("123456"), 5)
+	assert.Error(t, err)
+
+	b, err = readLimited(strings.NewReader("123456"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456", string(b))
+}
+
+func TestSlowValidate_Limits(t *testing.T) {
+	page := `<a href="https://bitworking.org/bar">link</a>` + strings.Repeat(" ", 1000)
+	contentType := "text/html"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		// Streamed, so there is no Content-Length.
+		w.(http.Flusher).Flush()
+		_, err := w.Write([]byte(page))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	mention := New(ts.URL+"/reply", "https://bitworking.org/bar")
+	assert.NoError(t, m.SlowValidate(ctx, mention, ts.Client()))
+
+	m.MaxSourceBytes = 100
+	err := m.SlowValidate(ctx, mention, ts.Client())
+	assert.Error(t, err)
+	assert.False(t, isTransient(err))
+
+	m.MaxSourceBytes = 0
+	contentType = "application/octet-stream"
+	err = m.SlowValidate(ctx, mention, ts.Client())
+	assert.Error(t, err)
+	assert.False(t, isTransient(err))
+}
+
+func TestVerifyQueuedMentions_OversizedSourceIsRecorded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<a href="https://bitworking.org/bar">link</a>` + strings.Repeat(" ", 1000)))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.MaxSourceBytes = 100
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	assert.Len(t, m.GetQueued(ctx), 0)
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Equal(t, SPAM_STATE, all[0].State)
+	assert.Contains(t, all[0].LastError, "larger than the limit")
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func TestDecodePhoto(t *testing.T) {
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	b := encodePNG(t, 100, 50)
+
+	img, err := m.decodePhoto(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+
+	m.MaxPhotoPixels = 100 * 49
+	_, err = m.decodePhoto(bytes.NewReader(b))
+	assert.Error(t, err)
+
+	m.MaxPhotoPixels = 0
+	m.MaxPhotoBytes = int64(len(b) - 1)
+	_, err = m.decodePhoto(bytes.NewReader(b))
+	assert.Error(t, err)
+
+	_, err = m.decodePhoto(strings.NewReader("not an image"))
+	assert.Error(t, err)
+}
+
+func TestSlowValidate_PhotoProblemsAreRecorded(t *testing.T) {
+	photo := encodePNG(t, 200, 200)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reply", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<article class="h-entry">
+			<a class="p-author h-card" href="/me"><img class="u-photo" src="/photo.png">Some Body</a>
+			<a href="https://bitworking.org/bar">link</a>
+		</article>`))
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write(photo)
+		assert.NoError(t, err)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.MaxPhotoPixels = 100 * 100
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Equal(t, GOOD_STATE, all[0].State)
+	assert.Equal(t, "Some Body", all[0].Author)
+	assert.Equal(t, "", all[0].Thumbnail)
+	assert.Contains(t, all[0].PhotoError, "exceed the limit")
+	assert.Equal(t, "", all[0].LastError)
+
+	// With the default limits the photo is used.
+	m.MaxPhotoPixels = DEFAULT_MAX_PHOTO_PIXELS
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	all = m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.NotEqual(t, "", all[0].Thumbnail)
+	assert.Equal(t, "", all[0].PhotoError)
+}
@@ -0,0 +1,143 @@
+package mention
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps everything in memory, useful for testing.
+//
+// All values are copied going in and out so that callers never share memory
+// with the store.
+type MemoryStore struct {
+	mutex      sync.Mutex
+	mentions   map[string]Mention
+	thumbnails map[string][]byte
+	sent       map[string]WebMentionSent
+	sources    map[string]SentSource
+}
+
+// NewMemoryStore creates a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mentions:   map[string]Mention{},
+		thumbnails: map[string][]byte{},
+		sent:       map[string]WebMentionSent{},
+		sources:    map[string]SentSource{},
+	}
+}
+
+func (s *MemoryStore) GetMention(ctx context.Context, key string) (*Mention, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mention, ok := s.mentions[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &mention, nil
+}
+
+func (s *MemoryStore) PutMention(ctx context.Context, key string, mention *Mention) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mentions[key] = *mention
+	return nil
+}
+
+// UpdateMention implements Store.
+//
+// The lock is held while f runs, so f must not call back into the store.
+func (s *MemoryStore) UpdateMention(ctx context.Context, key string, f func(*Mention) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mention, ok := s.mentions[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := f(&mention); err != nil {
+		return err
+	}
+	s.mentions[key] = mention
+	return nil
+}
+
+func (s *MemoryStore) DeleteMention(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.mentions, key)
+	return nil
+}
+
+func (s *MemoryStore) QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*MentionWithKey{}
+	for key, mention := range s.mentions {
+		if q.matches(&mention) {
+			ret = append(ret, &MentionWithKey{
+				Mention: mention,
+				Key:     key,
+			})
+		}
+	}
+	return q.sortAndPage(ret), nil
+}
+
+func (s *MemoryStore) GetThumbnail(ctx context.Context, id string) (*Thumbnail, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.thumbnails[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Thumbnail{
+		PNG: append([]byte{}, b...),
+	}, nil
+}
+
+func (s *MemoryStore) PutThumbnail(ctx context.Context, id string, t *Thumbnail) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.thumbnails[id] = append([]byte{}, t.PNG...)
+	return nil
+}
+
+func (s *MemoryStore) PutSent(ctx context.Context, key string, sent *WebMentionSent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent[key] = *sent
+	return nil
+}
+
+func (s *MemoryStore) QuerySent(ctx context.Context, q *SentQuery) ([]*WebMentionSent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*WebMentionSent{}
+	for _, sent := range s.sent {
+		if q.matches(&sent) {
+			sent := sent
+			ret = append(ret, &sent)
+		}
+	}
+	return q.sortAndPage(ret), nil
+}
+
+func (s *MemoryStore) GetSentSource(ctx context.Context, key string) (*SentSource, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	source, ok := s.sources[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &source, nil
+}
+
+func (s *MemoryStore) PutSentSource(ctx context.Context, key string, source *SentSource) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sources[key] = *source
+	return nil
+}
+
+// Assert that MemoryStore implements Store.
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,55 @@
+package mention
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStore_UpdateMentionIsAtomic(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	mention := New("https://example.com/", "https://bitworking.org/")
+	assert.NoError(t, s.PutMention(ctx, mention.Key(), mention))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.UpdateMention(ctx, mention.Key(), func(m *Mention) error {
+				m.Title += "x"
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.GetMention(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Len(t, got.Title, 50)
+}
+
+func TestMemoryStore_ValuesAreCopied(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	mention := New("https://example.com/", "https://bitworking.org/")
+	assert.NoError(t, s.PutMention(ctx, mention.Key(), mention))
+	mention.State = GOOD_STATE
+
+	got, err := s.GetMention(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, UNTRIAGED_STATE, got.State)
+	got.State = SPAM_STATE
+
+	got, err = s.GetMention(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, UNTRIAGED_STATE, got.State)
+}
@@ -4,34 +4,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
-	"image"
 	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
 	_ "image/png"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
-	"google.golang.org/api/iterator"
 	"willnorris.com/go/microformats"
 	"willnorris.com/go/webmention"
 
-	"github.com/jcgregorio/go-lib/ds"
 	"github.com/jcgregorio/slog"
-	"github.com/nfnt/resize"
-)
-
-const (
-	MENTIONS         ds.Kind = "Mentions"
-	WEB_MENTION_SENT ds.Kind = "WebMentionSent"
-	THUMBNAIL        ds.Kind = "Thumbnail"
+	"github.com/jcgregorio/webmention-run/safehttp"
 )
 
 func in(s string, arr []string) bool {
@@ -50,61 +39,224 @@ func (m *Mentions) close(c io.Closer) {
 }
 
 type Mentions struct {
-	DS  *ds.DS
-	log slog.Logger
+	store Store
+	log   slog.Logger
+
+	// VerifyParallelism is the number of queued mentions that
+	// VerifyQueuedMentions verifies at the same time.
+	VerifyParallelism int
+
+	// VerifyPerHost is the maximum number of sources that are fetched from a
+	// single host at the same time.
+	VerifyPerHost int
+
+	// VerifyMaxAttempts is the number of times verification is attempted
+	// before a transient failure is treated as permanent.
+	VerifyMaxAttempts int
+
+	// VerifyBackoff is the delay before the first retry of a transient
+	// failure. The delay doubles with each attempt, up to VerifyMaxBackoff.
+	VerifyBackoff    time.Duration
+	VerifyMaxBackoff time.Duration
+
+	// SendMaxAttempts is the number of times delivery of an outgoing
+	// webmention is attempted before giving up. Retries use the same backoff
+	// as verification.
+	SendMaxAttempts int
+
+	// MaxSourceBytes is the largest source that will be read, larger sources
+	// fail verification. 0 means no limit.
+	MaxSourceBytes int64
+
+	// SourceContentTypes are the allowed content types of sources.
+	SourceContentTypes []string
+
+	// MaxPhotoBytes and MaxPhotoPixels limit the size of the author photos
+	// that are turned into thumbnails. 0 means no limit.
+	MaxPhotoBytes  int64
+	MaxPhotoPixels int64
+
+	// PhotoContentTypes are the allowed content types of author photos.
+	PhotoContentTypes []string
 }
 
+// NewMentions creates a new Mentions stored in Google Cloud Datastore.
 func NewMentions(ctx context.Context, project, ns string, log slog.Logger) (*Mentions, error) {
-	d, err := ds.New(ctx, project, ns)
+	d, err := NewDatastoreStore(ctx, project, ns)
 	if err != nil {
 		return nil, err
 	}
+	return NewMentionsFromStore(d, log), nil
+}
+
+// NewMentionsFromStore creates a new Mentions that uses the given Store.
+func NewMentionsFromStore(store Store, log slog.Logger) *Mentions {
 	return &Mentions{
-		DS:  d,
-		log: log,
-	}, nil
+		store:             store,
+		log:               log,
+		VerifyParallelism: 4,
+		VerifyPerHost:     2,
+		VerifyMaxAttempts: 6,
+		VerifyBackoff:     5 * time.Minute,
+		VerifyMaxBackoff:  12 * time.Hour,
+		SendMaxAttempts:   6,
+
+		MaxSourceBytes:     DEFAULT_MAX_SOURCE_BYTES,
+		SourceContentTypes: DEFAULT_SOURCE_CONTENT_TYPES,
+		MaxPhotoBytes:      DEFAULT_MAX_PHOTO_BYTES,
+		MaxPhotoPixels:     DEFAULT_MAX_PHOTO_PIXELS,
+		PhotoContentTypes:  DEFAULT_PHOTO_CONTENT_TYPES,
+	}
 }
 
+// WebMentionSent records the delivery of a webmention from one of our posts,
+// Source, to a page it links to, Target.
 type WebMentionSent struct {
-	TS time.Time
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// TS is when the webmention was last sent, or an attempt was made to.
+	TS time.Time `json:"ts"`
+
+	// Updated is when the source was last updated as of when it was sent.
+	Updated time.Time `json:"updated" datastore:",noindex"`
+
+	// Endpoint is the target's webmention endpoint, empty if it has none.
+	Endpoint string `json:"endpoint,omitempty" datastore:",noindex"`
+
+	// Status is the HTTP status code the endpoint responded with.
+	Status int `json:"status,omitempty" datastore:",noindex"`
+
+	// Location is the Location header the endpoint responded with, if any,
+	// which is where the receiver reports on the webmention.
+	Location string `json:"location,omitempty" datastore:",noindex"`
+
+	// Attempts is the number of times delivery has been attempted.
+	Attempts int `json:"attempts" datastore:",noindex"`
+
+	// LastError is why the last delivery attempt failed, if it did.
+	LastError string `json:"last_error,omitempty" datastore:",noindex"`
+
+	// NextAttempt is when a failed delivery will be retried, zero if it won't
+	// be.
+	NextAttempt time.Time `json:"next_attempt,omitempty" datastore:",noindex"`
+
+	// Deleted is true if the webmention was sent because the source was
+	// deleted.
+	Deleted bool `json:"deleted,omitempty" datastore:",noindex"`
+
+	// Notified is true if the target has ever been sent a webmention from the
+	// source, which stays true even if its endpoint later can't be found.
+	Notified bool `json:"notified,omitempty" datastore:",noindex"`
 }
 
-func (m *Mentions) sent(source string) (time.Time, bool) {
-	key := m.DS.NewKey(WEB_MENTION_SENT)
-	key.Name = source
+// Key returns the key the record is stored under, which is derived from the
+// Source and Target.
+func (s *WebMentionSent) Key() string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s.Source+s.Target)))
+}
 
-	dst := &WebMentionSent{}
-	if err := m.DS.Client.Get(context.Background(), key, dst); err != nil {
-		m.log.Warningf("Failed to find source: %q", source)
-		return time.Time{}, false
-	} else {
-		m.log.Infof("Found source: %q", source)
-		return dst.TS, true
-	}
+// WasNotified returns true if the target has ever been sent a webmention from
+// the source. Records from before Notified was recorded only have Endpoint.
+func (s *WebMentionSent) WasNotified() bool {
+	return s.Notified || s.Endpoint != ""
 }
 
-func (m *Mentions) recordSent(source string, updated time.Time) error {
-	key := m.DS.NewKey(WEB_MENTION_SENT)
-	key.Name = source
+// SentSource records when webmentions were last sent from one of our posts,
+// which is recorded even for posts without any links, so the post isn't sent
+// again until it changes.
+type SentSource struct {
+	Source string `json:"source"`
 
-	src := &WebMentionSent{
-		TS: updated.UTC(),
-	}
-	_, err := m.DS.Client.Put(context.Background(), key, src)
-	return err
+	// TS is when webmentions were last sent from the source.
+	TS time.Time `json:"ts" datastore:",noindex"`
+
+	// Updated is when the source was last updated as of when it was sent.
+	Updated time.Time `json:"updated" datastore:",noindex"`
+
+	// Deleted is true if the webmentions were sent because the source was
+	// deleted.
+	Deleted bool `json:"deleted,omitempty" datastore:",noindex"`
+}
+
+// Key returns the key the record is stored under, which is derived from the
+// Source.
+func (s *SentSource) Key() string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s.Source)))
 }
 
 const (
 	GOOD_STATE      = "good"
 	UNTRIAGED_STATE = "untriaged"
 	SPAM_STATE      = "spam"
+
+	// DELETED_STATE is for mentions whose source has been deleted, or no
+	// longer links to the target.
+	DELETED_STATE = "deleted"
+)
+
+// The types of interaction a Mention can be, as found from the properties of
+// the source's h-entry.
+const (
+	REPLY_TYPE    = "reply"
+	LIKE_TYPE     = "like"
+	REPOST_TYPE   = "repost"
+	BOOKMARK_TYPE = "bookmark"
+	RSVP_TYPE     = "rsvp"
+	MENTION_TYPE  = "mention"
+)
+
+var (
+	// ErrSourceGone is returned from SlowValidate if the source responds with
+	// 410 Gone.
+	ErrSourceGone = errors.New("Source is gone.")
+
+	// ErrNoLink is returned from SlowValidate if the source doesn't link to the
+	// target.
+	ErrNoLink = errors.New("Failed to find target link in source.")
 )
 
 type Mention struct {
 	Source string
 	Target string
 	State  string
-	TS     time.Time
+
+	// TS is when the mention was first received.
+	TS time.Time
+
+	// Updated is when the mention was last re-sent, zero if it never was.
+	Updated time.Time `datastore:",noindex"`
+
+	// Reverify is true if the mention was re-sent after being triaged, in which
+	// case the source needs to be verified again, but State is kept.
+	Reverify bool
+
+	// PreviousState is the State the mention had before its source was
+	// deleted, which it returns to if the source is restored. Empty if the
+	// mention wasn't deleted by its source.
+	PreviousState string `datastore:",noindex"`
+
+	// StateChanged is when State was last changed, zero if it never was, or if
+	// it was last changed before StateChanged was recorded.
+	StateChanged time.Time `datastore:",noindex"`
+
+	// Moderated is true if State was set by a moderator, in which case it is
+	// never cleaned up, so the decision is kept if the mention is re-sent.
+	Moderated bool `datastore:",noindex"`
+
+	// Attempts is the number of times verification has been attempted.
+	Attempts int `datastore:",noindex"`
+
+	// LastError is why the last verification attempt failed, if it did.
+	LastError string `datastore:",noindex"`
+
+	// Reason is the short form of LastError that is reported to the sender,
+	// see reasonOf.
+	Reason string `datastore:",noindex"`
+
+	// NextAttempt is when verification should be retried after a transient
+	// failure. Zero if the mention can be verified now.
+	NextAttempt time.Time `datastore:",noindex"`
 
 	// Metadata found when validating. We might display this.
 	Title     string    `datastore:",noindex"`
@@ -113,6 +265,26 @@ type Mention struct {
 	Published time.Time `datastore:",noindex"`
 	Thumbnail string    `datastore:",noindex"`
 	URL       string    `datastore:",noindex"`
+
+	// PhotoError is why the author's photo couldn't be used for Thumbnail, if
+	// it couldn't. The mention is still good without one.
+	PhotoError string `datastore:",noindex"`
+
+	// ContentHTML is the content of the source's h-entry as sanitized HTML,
+	// safe to include in a page as is.
+	ContentHTML string `datastore:",noindex"`
+
+	// ContentText is the content of the source's h-entry as plain text,
+	// truncated to CONTENT_TEXT_LENGTH characters.
+	ContentText string `datastore:",noindex"`
+
+	// InReplyTo are the URLs the source's h-entry is in reply to, which
+	// besides the target may be other replies to it.
+	InReplyTo []string `datastore:",noindex"`
+
+	// Type is the type of interaction, e.g. LIKE_TYPE, empty until the source
+	// has been verified.
+	Type string
 }
 
 func New(source, target string) *Mention {
@@ -124,7 +296,9 @@ func New(source, target string) *Mention {
 	}
 }
 
-func (m *Mention) key() string {
+// Key returns the key the mention is stored under, which is derived from the
+// Source and Target.
+func (m *Mention) Key() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(m.Source+m.Target)))
 }
 
@@ -143,7 +317,7 @@ func (m *Mention) FastValidate(validTargets []string) error {
 		return fmt.Errorf("Target is not a valid URL: %s", err)
 	}
 
-	if !in(target.Hostname(), validTargets) {
+	if !onDomain(target.Hostname(), validTargets) {
 		return fmt.Errorf("Wrong target domain.")
 	}
 	if target.Scheme != "https" {
@@ -152,61 +326,221 @@ func (m *Mention) FastValidate(validTargets []string) error {
 	return nil
 }
 
-func (m *Mentions) SlowValidate(mention *Mention, c *http.Client) error {
+func (m *Mentions) SlowValidate(ctx context.Context, mention *Mention, c *http.Client) error {
 	m.log.Infof("SlowValidate: %q", mention.Source)
-	resp, err := c.Get(mention.Source)
+	req, err := http.NewRequest("GET", mention.Source, nil)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve source: %s", err)
+		return failure{UNREACHABLE_REASON, fmt.Errorf("Failed to build request: %s", err)}
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		if safehttp.IsRejected(err) {
+			return failure{BLOCKED_REASON, fmt.Errorf("Failed to retrieve source: %s", err)}
+		}
+		return transientError{failure{UNREACHABLE_REASON, fmt.Errorf("Failed to retrieve source: %s", err)}}
 	}
 	defer m.close(resp.Body)
-	b, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return ErrSourceGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := failure{UNREACHABLE_REASON, fmt.Errorf("Failed to retrieve source: %d", resp.StatusCode)}
+		if isTransientStatus(resp.StatusCode) {
+			return transientError{err}
+		}
+		return err
+	}
+	if err := contentTypeAllowed(resp.Header.Get("Content-Type"), m.SourceContentTypes); err != nil {
+		return failure{CONTENT_TYPE_REASON, fmt.Errorf("Failed to retrieve source: %s", err)}
+	}
+	if m.MaxSourceBytes > 0 && resp.ContentLength > m.MaxSourceBytes {
+		return failure{TOO_LARGE_REASON, fmt.Errorf("Failed to retrieve source: %d bytes is larger than the limit of %d bytes.", resp.ContentLength, m.MaxSourceBytes)}
+	}
+	b, err := readLimited(resp.Body, m.MaxSourceBytes)
 	if err != nil {
-		return fmt.Errorf("Failed to read content: %s", err)
+		if _, ok := err.(*limitError); ok {
+			return failure{TOO_LARGE_REASON, fmt.Errorf("Failed to read content: %s", err)}
+		}
+		return transientError{failure{UNREACHABLE_REASON, fmt.Errorf("Failed to read content: %s", err)}}
 	}
 	reader := bytes.NewReader(b)
 	links, err := webmention.DiscoverLinksFromReader(reader, mention.Source, "")
 	if err != nil {
-		return fmt.Errorf("Failed to discover links: %s", err)
+		return failure{NO_LINK_REASON, fmt.Errorf("Failed to discover links: %s", err)}
 	}
+	target := Canonicalize(mention.Target)
 	for _, link := range links {
-		if link == mention.Target {
+		if Canonicalize(link) == target {
 			_, err := reader.Seek(0, io.SeekStart)
 			if err != nil {
 				return nil
 			}
-			m.ParseMicroformats(mention, reader, MakeUrlToImageReader(c))
+			m.ParseMicroformats(ctx, mention, reader, MakeUrlToImageReader(c, m.PhotoContentTypes), MakeUrlToPageReader(c, m.SourceContentTypes))
 			return nil
 		}
 	}
-	return fmt.Errorf("Failed to find target link in source.")
+	return ErrNoLink
 }
 
-func (m *Mentions) ParseMicroformats(mention *Mention, r io.Reader, urlToImageReader UrlToImageReader) {
+func (m *Mentions) ParseMicroformats(ctx context.Context, mention *Mention, r io.Reader, urlToImageReader UrlToImageReader, urlToPageReader UrlToPageReader) {
 	u, err := url.Parse(mention.Source)
 	if err != nil {
 		return
 	}
 	data := microformats.Parse(r, u)
-	m.findHEntry(context.Background(), urlToImageReader, mention, data, data.Items)
+	m.findHEntry(ctx, urlToImageReader, urlToPageReader, mention, data, data.Items)
 }
 
-func (m *Mentions) VerifyQueuedMentions(c *http.Client) {
-	queued := m.GetQueued(context.Background())
-	m.log.Infof("About to slow verify %d queud mentions.", len(queued))
-	for _, mention := range queued {
-		mention.Published = time.Now()
-		mention.URL = mention.Source
-		m.log.Infof("Verifying queued webmention from %q", mention.Source)
-		if err := m.SlowValidate(mention, c); err == nil {
+// clearMetadata removes all the metadata found when validating.
+func (m *Mention) clearMetadata() {
+	m.Title = ""
+	m.Author = ""
+	m.AuthorURL = ""
+	m.Published = time.Time{}
+	m.Thumbnail = ""
+	m.URL = ""
+	m.PhotoError = ""
+	m.ContentHTML = ""
+	m.ContentText = ""
+	m.InReplyTo = nil
+}
+
+// verify validates a single queued mention and saves the result. If ctx
+// expires before the mention is verified then nothing is saved.
+//
+// New mentions become GOOD_STATE or SPAM_STATE. Mentions that are being
+// re-verified keep their State and only have their metadata refreshed, unless
+// they were previously deleted. In both cases a source that is gone, or a
+// re-sent source that no longer links to the target, is treated as a
+// deletion, as the Webmention spec requires.
+//
+// Transient failures leave the mention queued, to be retried with
+// exponential backoff, until VerifyMaxAttempts is reached.
+func (m *Mentions) verify(ctx context.Context, mention *Mention, c *http.Client) {
+	state := mention.State
+	verified := *mention
+	verified.clearMetadata()
+	verified.LastError = ""
+	verified.Reason = ""
+	// Sources without a dt-published are published when first verified, and
+	// keep that time when re-verified so they don't look new again in feeds.
+	verified.Published = mention.Published
+	if verified.Published.IsZero() {
+		verified.Published = time.Now()
+	}
+	verified.URL = verified.Source
+	m.log.Infof("Verifying queued webmention from %q", mention.Source)
+	err := m.SlowValidate(ctx, &verified, c)
+	if ctx.Err() != nil {
+		m.log.Infof("Ran out of time verifying %q, leaving it queued.", mention.Source)
+		return
+	}
+	mention.Attempts++
+	mention.NextAttempt = time.Time{}
+	mention.LastError = ""
+	if err != nil {
+		mention.LastError = err.Error()
+	}
+	mention.Reason = reasonOf(err)
+	if isTransient(err) && mention.Attempts < m.VerifyMaxAttempts {
+		mention.NextAttempt = time.Now().Add(m.backoff(mention.Attempts))
+		m.log.Infof("Will retry verifying %q after %s: %s", mention.Source, mention.NextAttempt, err)
+	} else if err == ErrSourceGone || (err == ErrNoLink && mention.Reverify) {
+		m.log.Infof("Webmention deleted: %q: %s", mention.Source, err)
+		published := mention.Published
+		mention.clearMetadata()
+		mention.Published = published
+		if mention.State != DELETED_STATE {
+			mention.PreviousState = mention.State
+		}
+		mention.State = DELETED_STATE
+		mention.Reverify = false
+	} else if mention.Reverify {
+		if err == nil {
+			verified.Attempts = mention.Attempts
+			*mention = verified
+			if mention.State == DELETED_STATE {
+				mention.State = restoredState(mention.PreviousState)
+				mention.PreviousState = ""
+			}
+		} else {
+			m.log.Infof("Failed to re-validate webmention, keeping old metadata: %#v: %s", *mention, err)
+		}
+		mention.Reverify = false
+	} else {
+		verified.Attempts = mention.Attempts
+		if err != nil {
+			verified.LastError = err.Error()
+		}
+		verified.Reason = reasonOf(err)
+		*mention = verified
+		if err == nil {
 			mention.State = GOOD_STATE
 		} else {
 			mention.State = SPAM_STATE
 			m.log.Infof("Failed to validate webmention: %#v: %s", *mention, err)
 		}
-		if err := m.Put(context.Background(), mention); err != nil {
-			m.log.Warningf("Failed to save validated message: %s", err)
-		}
 	}
+	if mention.State != state {
+		mention.StateChanged = time.Now()
+	}
+	if err := m.saveVerified(ctx, mention, state); err != nil {
+		m.log.Warningf("Failed to save validated message: %s", err)
+	}
+}
+
+// restoredState returns the state of a deleted mention whose source has been
+// restored, given the state it had before it was deleted.
+//
+// Deletion isn't a moderation decision, so the mention gets back the state it
+// had, and one that was never triaged is good since it verified. Without a
+// previous state the deletion was either made by a moderator or predates
+// PreviousState, so the mention stays deleted to never override a moderator.
+func restoredState(previous string) string {
+	switch previous {
+	case "":
+		return DELETED_STATE
+	case UNTRIAGED_STATE:
+		return GOOD_STATE
+	}
+	return previous
+}
+
+// saveVerified writes back the result of verifying a mention that had the
+// given state when verification started. Only the fields that verification
+// sets are written, so anything else changed in the meantime is kept. If a
+// moderator changed the state in the meantime their decision is kept, and if
+// the mention was re-sent it is left queued for re-verification.
+func (m *Mentions) saveVerified(ctx context.Context, mention *Mention, state string) error {
+	return m.store.UpdateMention(ctx, mention.Key(), func(stored *Mention) error {
+		if !stored.Moderated || (mention.Moderated && stored.State == state) {
+			stored.State = mention.State
+			stored.PreviousState = mention.PreviousState
+			stored.StateChanged = mention.StateChanged
+		}
+		stored.Reverify = mention.Reverify
+		stored.Attempts = mention.Attempts
+		stored.LastError = mention.LastError
+		stored.Reason = mention.Reason
+		stored.NextAttempt = mention.NextAttempt
+		stored.Type = mention.Type
+		stored.Title = mention.Title
+		stored.Author = mention.Author
+		stored.AuthorURL = mention.AuthorURL
+		stored.Published = mention.Published
+		stored.Thumbnail = mention.Thumbnail
+		stored.PhotoError = mention.PhotoError
+		stored.URL = mention.URL
+		stored.ContentHTML = mention.ContentHTML
+		stored.ContentText = mention.ContentText
+		stored.InReplyTo = mention.InReplyTo
+		if stored.Updated.After(mention.Updated) {
+			stored.Reverify = stored.State != UNTRIAGED_STATE
+			stored.Attempts = 0
+			stored.NextAttempt = time.Time{}
+		}
+		return nil
+	})
 }
 
 type MentionSlice []*Mention
@@ -215,26 +549,23 @@ func (p MentionSlice) Len() int           { return len(p) }
 func (p MentionSlice) Less(i, j int) bool { return p[i].TS.Before(p[j].TS) }
 func (p MentionSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// get returns the mentions of target, which is canonicalized, oldest first.
+// Only good mentions are returned unless all is true.
 func (m *Mentions) get(ctx context.Context, target string, all bool) []*Mention {
 	ret := []*Mention{}
-	q := m.DS.NewQuery(MENTIONS).
-		Filter("Target =", target)
+	q := &Query{
+		Target: Canonicalize(target),
+	}
 	if !all {
-		q = q.Filter("State =", GOOD_STATE)
+		q.State = GOOD_STATE
 	}
-
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		mention := &Mention{}
-		_, err := it.Next(mention)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			m.log.Infof("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, mention)
+	mentions, err := m.store.QueryMentions(ctx, q)
+	if err != nil {
+		m.log.Infof("Failed while reading: %s", err)
+	}
+	for _, mention := range mentions {
+		mention := mention.Mention
+		ret = append(ret, &mention)
 	}
 	sort.Sort(MentionSlice(ret))
 	return ret
@@ -248,29 +579,131 @@ func (m *Mentions) GetGood(ctx context.Context, target string) []*Mention {
 	return m.get(ctx, target, false)
 }
 
-func (m *Mentions) UpdateState(ctx context.Context, encodedKey, state string) error {
-	tx, err := m.DS.Client.NewTransaction(ctx)
+// GetGoodPage returns the good mentions of target that were received after
+// since, oldest first, skipping the first offset and returning at most limit
+// of them, 0 meaning no limit. A zero since returns all of them. If typ isn't
+// empty only mentions of that type, e.g. LIKE_TYPE, are returned.
+func (m *Mentions) GetGoodPage(ctx context.Context, target, typ string, since time.Time, limit, offset int) []*Mention {
+	ret := []*Mention{}
+	for _, mention := range m.GetGood(ctx, target) {
+		if mention.TS.After(since) && (typ == "" || mention.Type == typ) {
+			ret = append(ret, mention)
+		}
+	}
+	return page(ret, limit, offset)
+}
+
+// GetRecentGood returns the good mentions of every target that were received
+// after since, newest first, skipping the first offset and returning at most
+// limit of them, 0 meaning no limit. A zero since returns all of them. If typ
+// isn't empty only mentions of that type, e.g. LIKE_TYPE, are returned.
+func (m *Mentions) GetRecentGood(ctx context.Context, typ string, since time.Time, limit, offset int) []*Mention {
+	ret := []*Mention{}
+	mentions, err := m.store.QueryMentions(ctx, &Query{
+		State:       GOOD_STATE,
+		Type:        typ,
+		NewestFirst: true,
+	})
 	if err != nil {
-		return fmt.Errorf("client.NewTransaction: %v", err)
+		m.log.Infof("Failed while reading: %s", err)
+	}
+	for _, mention := range mentions {
+		if mention.TS.After(since) {
+			mention := mention.Mention
+			ret = append(ret, &mention)
+		}
 	}
-	key, err := datastore.DecodeKey(encodedKey)
+	return page(ret, limit, offset)
+}
+
+// GetRecentGoodOf returns the most recent good mentions of targets on one of
+// domains, newest first, at most limit of them, 0 meaning no limit. If filter
+// isn't empty then only targets that match it are returned, see
+// TargetMatches.
+func (m *Mentions) GetRecentGoodOf(ctx context.Context, domains []string, filter string, limit int) []*Mention {
+	ret := []*Mention{}
+	for _, mention := range m.GetRecentGood(ctx, "", time.Time{}, 0, 0) {
+		if !TargetMatches(mention.Target, domains...) {
+			continue
+		}
+		if filter != "" && !TargetMatches(mention.Target, filter) {
+			continue
+		}
+		ret = append(ret, mention)
+	}
+	return page(ret, limit, 0)
+}
+
+// TargetMatches returns true if target matches any of the filters, each of
+// which is either a domain, e.g. "bitworking.org", that the target must be
+// on, or a URL prefix, e.g. "https://bitworking.org/news/", that the target
+// must start with. A prefix that ends in a slash only matches whole path
+// segments.
+//
+// Both the target and the filters are canonicalized first, see Canonicalize,
+// so "www.bitworking.org" and "http://bitworking.org/news/" match the same
+// targets as the filters above.
+func TargetMatches(target string, filters ...string) bool {
+	target = Canonicalize(target)
+	u, err := url.Parse(target)
 	if err != nil {
-		return fmt.Errorf("Unable to decode key: %s", err)
+		return false
+	}
+	for _, filter := range filters {
+		if strings.Contains(filter, "://") {
+			prefix := Canonicalize(filter)
+			if strings.HasSuffix(filter, "/") {
+				if target == prefix || strings.HasPrefix(target, prefix+"/") {
+					return true
+				}
+			} else if strings.HasPrefix(target, prefix) {
+				return true
+			}
+		} else if onDomain(u.Hostname(), []string{filter}) {
+			return true
+		}
 	}
-	var mention Mention
-	if err := tx.Get(key, &mention); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.GetMulti: %v", err)
+	return false
+}
+
+// onDomain returns true if host is one of the domains, ignoring case and any
+// leading "www.", as Canonicalize does.
+func onDomain(host string, domains []string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	for _, domain := range domains {
+		if host == strings.TrimPrefix(strings.ToLower(domain), "www.") {
+			return true
+		}
 	}
-	mention.State = state
-	if _, err := tx.Put(key, &mention); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.Put: %v", err)
+	return false
+}
+
+// page skips the first offset mentions and returns at most limit of the
+// rest, 0 meaning no limit.
+func page(ret []*Mention, limit, offset int) []*Mention {
+	if offset > 0 {
+		if offset >= len(ret) {
+			return []*Mention{}
+		}
+		ret = ret[offset:]
 	}
-	if _, err = tx.Commit(); err != nil {
-		return fmt.Errorf("tx.Commit: %v", err)
+	if limit > 0 && limit < len(ret) {
+		ret = ret[:limit]
 	}
-	return nil
+	return ret
+}
+
+// UpdateState sets the state of a mention, as decided by a moderator.
+func (m *Mentions) UpdateState(ctx context.Context, key, state string) error {
+	return m.store.UpdateMention(ctx, key, func(mention *Mention) error {
+		if mention.State != state {
+			mention.StateChanged = time.Now()
+		}
+		mention.State = state
+		mention.PreviousState = ""
+		mention.Moderated = true
+		return nil
+	})
 }
 
 type MentionWithKey struct {
@@ -279,60 +712,140 @@ type MentionWithKey struct {
 }
 
 func (m *Mentions) GetTriage(ctx context.Context, limit, offset int) []*MentionWithKey {
-	ret := []*MentionWithKey{}
-	q := m.DS.NewQuery(MENTIONS).Order("-TS").Limit(limit).Offset(offset)
+	ret, err := m.store.QueryMentions(ctx, &Query{
+		NewestFirst: true,
+		Limit:       limit,
+		Offset:      offset,
+	})
+	if err != nil {
+		m.log.Infof("Failed while reading: %s", err)
+	}
+	return ret
+}
 
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		var mention Mention
-		key, err := it.Next(&mention)
-		if err == iterator.Done {
-			break
-		}
+// GetQueued returns all the mentions that need to be verified, i.e. new
+// mentions and those that need to be re-verified, skipping any that are
+// waiting to be retried.
+func (m *Mentions) GetQueued(ctx context.Context) []*Mention {
+	ret := []*Mention{}
+	seen := map[string]bool{}
+	now := time.Now()
+	for _, q := range []*Query{{State: UNTRIAGED_STATE}, {Reverify: true}} {
+		mentions, err := m.store.QueryMentions(ctx, q)
 		if err != nil {
 			m.log.Infof("Failed while reading: %s", err)
-			break
 		}
-		ret = append(ret, &MentionWithKey{
-			Mention: mention,
-			Key:     key.Encode(),
-		})
+		for _, mention := range mentions {
+			if seen[mention.Key] || mention.NextAttempt.After(now) {
+				continue
+			}
+			seen[mention.Key] = true
+			mention := mention.Mention
+			ret = append(ret, &mention)
+		}
 	}
 	return ret
 }
 
-func (m *Mentions) GetQueued(ctx context.Context) []*Mention {
-	ret := []*Mention{}
-	q := m.DS.NewQuery(MENTIONS).
-		Filter("State =", UNTRIAGED_STATE)
-
-	it := m.DS.Client.Run(ctx, q)
-	for {
-		mention := &Mention{}
-		_, err := it.Next(mention)
-		if err == iterator.Done {
-			break
+// QueueReverify queues every good mention to be verified again, so that
+// mentions whose source has since been deleted, or no longer links to the
+// target, are found even if the source never re-sends the webmention.
+func (m *Mentions) QueueReverify(ctx context.Context) error {
+	mentions, err := m.store.QueryMentions(ctx, &Query{State: GOOD_STATE})
+	if err != nil {
+		return fmt.Errorf("Failed to find good mentions: %s", err)
+	}
+	m.log.Infof("Queueing %d good mentions for re-verification.", len(mentions))
+	for _, mention := range mentions {
+		if mention.Reverify {
+			continue
 		}
+		err := m.store.UpdateMention(ctx, mention.Key, func(stored *Mention) error {
+			stored.Reverify = stored.State == GOOD_STATE
+			return nil
+		})
+		if err != nil && err != ErrNotFound {
+			return fmt.Errorf("Failed to queue %q: %s", mention.Key, err)
+		}
+	}
+	return nil
+}
+
+// Cleanup removes spam and deleted mentions that got into that state more
+// than olderThan ago. Mentions whose state was set by a moderator are kept,
+// so a re-sent mention doesn't get verified again as if it were new.
+func (m *Mentions) Cleanup(ctx context.Context, olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan)
+	count := 0
+	for _, state := range []string{SPAM_STATE, DELETED_STATE} {
+		mentions, err := m.store.QueryMentions(ctx, &Query{State: state})
 		if err != nil {
-			m.log.Infof("Failed while reading: %s", err)
-			break
+			return fmt.Errorf("Failed to find %s mentions: %s", state, err)
+		}
+		for _, mention := range mentions {
+			if !expired(&mention.Mention, cutoff) {
+				continue
+			}
+			if err := m.store.DeleteMention(ctx, mention.Key); err != nil {
+				return fmt.Errorf("Failed to delete %q: %s", mention.Key, err)
+			}
+			count++
 		}
-		ret = append(ret, mention)
 	}
-	return ret
+	m.log.Infof("Cleaned up %d mentions.", count)
+	return nil
 }
 
+// expired returns true if the spam or deleted mention can be cleaned up
+// because it got into that state before cutoff.
+//
+// For mentions whose state changed before StateChanged was recorded the time
+// of the change is taken to be when the mention was last received, and since
+// a mention that verified without error can only have been marked as spam or
+// deleted by a moderator, those are kept.
+func expired(mention *Mention, cutoff time.Time) bool {
+	if mention.Moderated {
+		return false
+	}
+	changed := mention.StateChanged
+	if changed.IsZero() {
+		if mention.LastError == "" {
+			return false
+		}
+		changed = mention.TS
+		if mention.Updated.After(changed) {
+			changed = mention.Updated
+		}
+	}
+	return changed.Before(cutoff)
+}
+
+// Put stores a received mention.
+//
+// If the mention has been received before then the original State, TS, and
+// metadata are kept, Updated is set, and the mention is queued for
+// re-verification, without waiting for any pending retry.
 func (m *Mentions) Put(ctx context.Context, mention *Mention) error {
-	// TODO See if there's an existing mention already, so we don't overwrite its status?
-	key := m.DS.NewKey(MENTIONS)
-	key.Name = mention.key()
-	if _, err := m.DS.Client.Put(ctx, key, mention); err != nil {
+	err := m.store.UpdateMention(ctx, mention.Key(), func(existing *Mention) error {
+		existing.Updated = time.Now()
+		existing.Reverify = existing.State != UNTRIAGED_STATE
+		existing.Attempts = 0
+		existing.NextAttempt = time.Time{}
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+	if err != ErrNotFound {
+		return fmt.Errorf("Failed updating %#v: %s", *mention, err)
+	}
+	if err := m.store.PutMention(ctx, mention.Key(), mention); err != nil {
 		return fmt.Errorf("Failed writing %#v: %s", *mention, err)
 	}
 	return nil
 }
 
-type UrlToImageReader func(url string) (io.ReadCloser, error)
+type UrlToImageReader func(ctx context.Context, url string) (io.ReadCloser, error)
 
 func firstPropAsString(uf *microformats.Microformat, key string) string {
 	for _, sint := range uf.Properties[key] {
@@ -343,7 +856,7 @@ func firstPropAsString(uf *microformats.Microformat, key string) string {
 	return ""
 }
 
-func (m *Mentions) findHEntry(ctx context.Context, u2r UrlToImageReader, mention *Mention, data *microformats.Data, items []*microformats.Microformat) {
+func (m *Mentions) findHEntry(ctx context.Context, u2r UrlToImageReader, u2p UrlToPageReader, mention *Mention, data *microformats.Data, items []*microformats.Microformat) {
 	for _, it := range items {
 		if in("h-entry", it.Type) {
 			mention.Title = firstPropAsString(it, "name")
@@ -353,109 +866,117 @@ func (m *Mentions) findHEntry(ctx context.Context, u2r UrlToImageReader, mention
 			if strings.HasPrefix(mention.Title, "tag:twitter") {
 				mention.Title = "Twitter"
 			}
-			if firstPropAsString(it, "like-of") != "" {
-				mention.Title += " Like"
-			}
-			if firstPropAsString(it, "repost-of") != "" {
-				mention.Title += " Repost"
-			}
+			mention.Type = findType(it, mention.Target)
+			mention.InReplyTo = propURLs(it, "in-reply-to")
+			mention.ContentHTML, mention.ContentText = findContent(it, mention.Source)
 			if url := firstPropAsString(it, "url"); url != "" {
 				mention.URL = url
 			}
 			if t, err := time.Parse(time.RFC3339, firstPropAsString(it, "published")); err == nil {
 				mention.Published = t
 			}
-			if authorsInt, ok := it.Properties["author"]; ok {
-				for _, authorInt := range authorsInt {
-					if author, ok := authorInt.(*microformats.Microformat); ok {
-						m.findAuthor(ctx, u2r, mention, data, author)
-					}
+			m.findAuthor(ctx, u2r, u2p, mention, data, it)
+		}
+		m.findHEntry(ctx, u2r, u2p, mention, data, it.Children)
+	}
+}
+
+// findType returns the type of interaction of the h-entry with target,
+// following the order of precedence of Post Type Discovery. A property only
+// counts if it refers to target, so an h-entry that likes some other page and
+// just links to target is a mention of it.
+func findType(it *microformats.Microformat, target string) string {
+	replyTo := refersTo(it, "in-reply-to", target)
+	switch {
+	case replyTo && firstPropAsString(it, "rsvp") != "":
+		return RSVP_TYPE
+	case replyTo:
+		return REPLY_TYPE
+	case refersTo(it, "repost-of", target):
+		return REPOST_TYPE
+	case refersTo(it, "like-of", target):
+		return LIKE_TYPE
+	case refersTo(it, "bookmark-of", target):
+		return BOOKMARK_TYPE
+	}
+	return MENTION_TYPE
+}
+
+// refersTo returns true if any value of the property is target, either as a
+// URL or as the url of an embedded h-cite.
+func refersTo(it *microformats.Microformat, key, target string) bool {
+	target = Canonicalize(target)
+	for _, u := range propURLs(it, key) {
+		if Canonicalize(u) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// propURLs returns the URLs that the values of the property refer to, either
+// directly or as the url of an embedded h-cite, without duplicates.
+func propURLs(it *microformats.Microformat, key string) []string {
+	var ret []string
+	add := func(u string) {
+		if u == "" {
+			return
+		}
+		for _, existing := range ret {
+			if existing == u {
+				return
+			}
+		}
+		ret = append(ret, u)
+	}
+	for _, v := range it.Properties[key] {
+		switch value := v.(type) {
+		case string:
+			add(value)
+		case *microformats.Microformat:
+			add(value.Value)
+			for _, u := range value.Properties["url"] {
+				if s, ok := u.(string); ok {
+					add(s)
 				}
 			}
 		}
-		m.findHEntry(ctx, u2r, mention, data, it.Children)
 	}
+	return ret
 }
 
 type Thumbnail struct {
 	PNG []byte `datastore:",noindex"`
 }
 
-func MakeUrlToImageReader(c *http.Client) UrlToImageReader {
-	return func(u string) (io.ReadCloser, error) {
-		resp, err := c.Get(u)
+// MakeUrlToImageReader returns a UrlToImageReader that fetches images with
+// the given client, refusing any response whose Content-Type isn't in
+// contentTypes.
+func MakeUrlToImageReader(c *http.Client, contentTypes []string) UrlToImageReader {
+	return func(ctx context.Context, u string) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to build request: %s", err)
+		}
+		resp, err := c.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("Error retrieving thumbnail: %s", err)
 		}
 		if resp.StatusCode != 200 {
+			_ = resp.Body.Close()
 			return nil, fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
 		}
+		if err := contentTypeAllowed(resp.Header.Get("Content-Type"), contentTypes); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
 		return resp.Body, nil
 	}
 }
 
-func (m *Mentions) findAuthor(ctx context.Context, u2r UrlToImageReader, mention *Mention, data *microformats.Data, it *microformats.Microformat) {
-	mention.Author = it.Value
-	if len(data.Rels["author"]) > 0 {
-		mention.AuthorURL = data.Rels["author"][0]
-	} else {
-		mention.AuthorURL = firstPropAsString(it, "url")
-	}
-	u := firstPropAsString(it, "photo")
-	if u == "" {
-		m.log.Infof("No photo URL found.")
-		return
-	}
-
-	r, err := u2r(u)
-	if err != nil {
-		m.log.Infof("Failed to retrieve photo.")
-		return
-	}
-
-	defer m.close(r)
-	img, _, err := image.Decode(r)
-	if err != nil {
-		m.log.Infof("Failed to decode photo.")
-		return
-	}
-	rect := img.Bounds()
-	var x uint = 32
-	var y uint = 32
-	if rect.Max.X > rect.Max.Y {
-		y = 0
-	} else {
-		x = 0
-	}
-	resized := resize.Resize(x, y, img, resize.Lanczos3)
-
-	var buf bytes.Buffer
-	encoder := png.Encoder{
-		CompressionLevel: png.BestCompression,
-	}
-	if err := encoder.Encode(&buf, resized); err != nil {
-		m.log.Errorf("Failed to encode photo.")
-		return
-	}
-
-	hash := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
-	t := &Thumbnail{
-		PNG: buf.Bytes(),
-	}
-	key := m.DS.NewKey(THUMBNAIL)
-	key.Name = hash
-	if _, err := m.DS.Client.Put(ctx, key, t); err != nil {
-		m.log.Errorf("Failed to write: %s", err)
-		return
-	}
-	mention.Thumbnail = hash
-}
-
 func (m *Mentions) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
-	key := m.DS.NewKey(THUMBNAIL)
-	key.Name = id
-	var t Thumbnail
-	if err := m.DS.Client.Get(ctx, key, &t); err != nil {
+	t, err := m.store.GetThumbnail(ctx, id)
+	if err != nil {
 		return nil, fmt.Errorf("Failed to find image: %s", err)
 	}
 	return t.PNG, nil
@@ -3,12 +3,18 @@ package mention
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"fmt"
+	"image/png"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,9 +26,8 @@ import (
 	"willnorris.com/go/microformats"
 )
 
-// InitDatastore is a common utility function used in tests. It sets up the
-// datastore to connect to the emulator and also clears out all instances of
-// the given 'kinds' from the datastore.
+// InitForTesting is a common utility function used in tests. It sets up the
+// datastore to connect to the emulator in a fresh namespace.
 func InitForTesting(t assert.TestingT) *Mentions {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	emulatorHost := os.Getenv("DATASTORE_EMULATOR_HOST")
@@ -53,36 +58,529 @@ to set the environment variables. When done running tests you can unset the env
 	return m
 }
 
+// forEachStore runs f as a subtest once for each Store that can be tested in
+// the current environment. The MemoryStore and BoltStore are always tested,
+// Cloud Datastore only if DATASTORE_EMULATOR_HOST is set, and PostgreSQL only
+// if POSTGRES_TEST_URL is set.
+func forEachStore(t *testing.T, f func(t *testing.T, m *Mentions)) {
+	t.Run("Memory", func(t *testing.T) {
+		f(t, NewMentionsFromStore(NewMemoryStore(), logger.New()))
+	})
+	t.Run("Bolt", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "mention")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+		s, err := NewBoltStore(filepath.Join(dir, "webmention.db"))
+		assert.NoError(t, err)
+		defer s.Close()
+		f(t, NewMentionsFromStore(s, logger.New()))
+	})
+	if os.Getenv("DATASTORE_EMULATOR_HOST") != "" {
+		t.Run("Datastore", func(t *testing.T) {
+			f(t, InitForTesting(t))
+		})
+	}
+	if os.Getenv("POSTGRES_TEST_URL") != "" {
+		t.Run("Postgres", func(t *testing.T) {
+			s, cleanup := newPostgresStoreForTesting(t)
+			defer cleanup()
+			f(t, NewMentionsFromStore(s, logger.New()))
+		})
+	}
+}
+
+// assertThumbnail confirms that a 32px high PNG thumbnail of the portrait test
+// image is stored under its hash.
+func assertThumbnail(t *testing.T, m *Mentions, id string) {
+	b, err := m.GetThumbnail(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, fmt.Sprintf("%x", md5.Sum(b)))
+	img, err := png.Decode(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, 32, img.Bounds().Dy())
+}
+
 func TestDB(t *testing.T) {
-	m := InitForTesting(t)
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+
+		err := m.Put(context.Background(), &Mention{
+			Source: "https://stackoverflow.com/foo",
+			Target: "https://bitworking.org/bar",
+			State:  GOOD_STATE,
+			TS:     time.Now(),
+		})
+		assert.NoError(t, err)
+
+		err = m.Put(context.Background(), &Mention{
+			Source: "https://spam.com/foo",
+			Target: "https://bitworking.org/bar",
+			State:  SPAM_STATE,
+			TS:     time.Now(),
+		})
+		assert.NoError(t, err)
+
+		err = m.Put(context.Background(), &Mention{
+			Source: "https://news.ycombinator.com/foo",
+			Target: "https://bitworking.org/bar",
+			State:  GOOD_STATE,
+			TS:     time.Now(),
+		})
+		assert.NoError(t, err)
+		time.Sleep(2)
 
-	err := m.Put(context.Background(), &Mention{
-		Source: "https://stackoverflow.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  GOOD_STATE,
-		TS:     time.Now(),
+		mentions := m.GetGood(context.Background(), "https://bitworking.org/bar")
+		assert.Len(t, mentions, 2)
 	})
-	assert.NoError(t, err)
+}
+
+func TestGetGoodPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 5; i++ {
+			mention := New(fmt.Sprintf("https://example.com/%d", i), "https://bitworking.org/bar")
+			mention.TS = start.Add(time.Duration(i) * time.Hour)
+			mention.State = GOOD_STATE
+			assert.NoError(t, m.store.PutMention(ctx, mention.Key(), mention))
+		}
+		spam := New("https://example.com/spam", "https://bitworking.org/bar")
+		spam.State = SPAM_STATE
+		assert.NoError(t, m.store.PutMention(ctx, spam.Key(), spam))
+
+		all := m.GetGoodPage(ctx, "https://bitworking.org/bar", "", time.Time{}, 0, 0)
+		assert.Len(t, all, 5)
+		assert.Equal(t, "https://example.com/0", all[0].Source)
+
+		page := m.GetGoodPage(ctx, "https://bitworking.org/bar", "", time.Time{}, 2, 2)
+		assert.Len(t, page, 2)
+		assert.Equal(t, "https://example.com/2", page[0].Source)
+		assert.Equal(t, "https://example.com/3", page[1].Source)
+
+		since := m.GetGoodPage(ctx, "https://bitworking.org/bar", "", start.Add(2*time.Hour), 0, 0)
+		assert.Len(t, since, 2)
+		assert.Equal(t, "https://example.com/3", since[0].Source)
+
+		assert.Len(t, m.GetGoodPage(ctx, "https://bitworking.org/bar", "", time.Time{}, 10, 10), 0)
 
-	err = m.Put(context.Background(), &Mention{
-		Source: "https://spam.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  SPAM_STATE,
-		TS:     time.Now(),
+		liked := New("https://example.com/like", "https://bitworking.org/bar")
+		liked.State = GOOD_STATE
+		liked.Type = LIKE_TYPE
+		assert.NoError(t, m.store.PutMention(ctx, liked.Key(), liked))
+		likes := m.GetGoodPage(ctx, "https://bitworking.org/bar", LIKE_TYPE, time.Time{}, 0, 0)
+		assert.Len(t, likes, 1)
+		assert.Equal(t, "https://example.com/like", likes[0].Source)
 	})
-	assert.NoError(t, err)
+}
+
+func TestGetRecentGood(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+		for i, target := range []string{"https://bitworking.org/bar", "https://bitworking.org/baz", "https://example.org/other"} {
+			mention := New(fmt.Sprintf("https://example.com/%d", i), target)
+			mention.TS = start.Add(time.Duration(i) * time.Hour)
+			mention.State = GOOD_STATE
+			assert.NoError(t, m.store.PutMention(ctx, mention.Key(), mention))
+		}
+		assert.NoError(t, m.Put(ctx, New("https://example.com/untriaged", "https://bitworking.org/bar")))
 
-	err = m.Put(context.Background(), &Mention{
-		Source: "https://news.ycombinator.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  GOOD_STATE,
-		TS:     time.Now(),
+		recent := m.GetRecentGood(ctx, "", time.Time{}, 0, 0)
+		assert.Len(t, recent, 3)
+		assert.Equal(t, "https://example.org/other", recent[0].Target)
+		assert.Equal(t, "https://bitworking.org/bar", recent[2].Target)
+
+		recent = m.GetRecentGood(ctx, "", start, 1, 1)
+		assert.Len(t, recent, 1)
+		assert.Equal(t, "https://bitworking.org/baz", recent[0].Target)
+
+		assert.Len(t, m.GetRecentGood(ctx, REPLY_TYPE, time.Time{}, 0, 0), 0)
 	})
-	assert.NoError(t, err)
-	time.Sleep(2)
+}
+
+func TestGetRecentGoodOf(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+		for i, target := range []string{"https://bitworking.org/news/a", "https://bitworking.org/about", "https://stream.bitworking.org/1", "https://example.org/other"} {
+			mention := New(fmt.Sprintf("https://example.com/%d", i), target)
+			mention.TS = start.Add(time.Duration(i) * time.Hour)
+			mention.State = GOOD_STATE
+			assert.NoError(t, m.store.PutMention(ctx, mention.Key(), mention))
+		}
+		domains := []string{"bitworking.org", "stream.bitworking.org"}
+
+		recent := m.GetRecentGoodOf(ctx, domains, "", 0)
+		assert.Len(t, recent, 3)
+		assert.Equal(t, "https://stream.bitworking.org/1", recent[0].Target)
+
+		assert.Len(t, m.GetRecentGoodOf(ctx, domains, "", 2), 2)
+		assert.Len(t, m.GetRecentGoodOf(ctx, domains, "bitworking.org", 0), 2)
+		assert.Len(t, m.GetRecentGoodOf(ctx, domains, "example.org", 0), 0)
+
+		recent = m.GetRecentGoodOf(ctx, domains, "https://bitworking.org/news/", 0)
+		assert.Len(t, recent, 1)
+		assert.Equal(t, "https://bitworking.org/news/a", recent[0].Target)
+
+		// A site configured with www, and filtered by an http URL.
+		recent = m.GetRecentGoodOf(ctx, []string{"www.bitworking.org"}, "http://bitworking.org/news/", 0)
+		assert.Len(t, recent, 1)
+		assert.Equal(t, "https://bitworking.org/news/a", recent[0].Target)
+		assert.Len(t, m.GetRecentGoodOf(ctx, []string{"www.bitworking.org"}, "", 0), 2)
+	})
+}
+
+func TestTargetMatches(t *testing.T) {
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "bitworking.org"))
+	assert.True(t, TargetMatches("https://Bitworking.org/news/a", "bitworking.org"))
+	assert.False(t, TargetMatches("https://stream.bitworking.org/1", "bitworking.org"))
+	assert.True(t, TargetMatches("https://stream.bitworking.org/1", "bitworking.org", "stream.bitworking.org"))
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "https://bitworking.org/news/"))
+	assert.False(t, TargetMatches("https://bitworking.org/about", "https://bitworking.org/news/"))
+	assert.False(t, TargetMatches("https://bitworking.org/news/a"))
+
+	// Domains and prefixes are canonicalized like targets are.
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "www.bitworking.org"))
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "WWW.Bitworking.org"))
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "http://bitworking.org/news/"))
+	assert.True(t, TargetMatches("https://bitworking.org/news/a", "https://www.bitworking.org/news/"))
+	assert.True(t, TargetMatches("https://bitworking.org/news", "http://bitworking.org/news/"))
+	assert.False(t, TargetMatches("https://bitworking.org/newsletter", "https://bitworking.org/news/"))
+	assert.True(t, TargetMatches("https://bitworking.org/news/2019/a", "http://www.bitworking.org/news/2019"))
+}
+
+func TestPut_ResendKeepsTriageState(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		first := New("https://example.com/reply", "https://bitworking.org/bar")
+		assert.NoError(t, m.Put(ctx, first))
+		assert.Len(t, m.GetQueued(ctx), 1)
+
+		triaged := m.GetTriage(ctx, 10, 0)
+		assert.Len(t, triaged, 1)
+		assert.NoError(t, m.UpdateState(ctx, triaged[0].Key, GOOD_STATE))
+		assert.Len(t, m.GetQueued(ctx), 0)
 
-	mentions := m.GetGood(context.Background(), "https://bitworking.org/bar")
-	assert.Len(t, mentions, 2)
+		// Re-send the same mention.
+		assert.NoError(t, m.Put(ctx, New("https://example.com/reply", "https://bitworking.org/bar")))
+
+		good := m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		assert.True(t, first.TS.Equal(good[0].TS))
+		assert.False(t, good[0].Updated.IsZero())
+		assert.True(t, good[0].Reverify)
+
+		queued := m.GetQueued(ctx)
+		assert.Len(t, queued, 1)
+		assert.Equal(t, GOOD_STATE, queued[0].State)
+	})
+}
+
+func TestVerifyQueuedMentions_Reverify(t *testing.T) {
+	page := `<article class="h-entry"><h1 class="p-name">First Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(page))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		source := ts.URL + "/reply"
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+
+		good := m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		assert.Equal(t, "First Title", good[0].Title)
+
+		// Mark it as spam, then have the source change and re-send.
+		triaged := m.GetTriage(ctx, 10, 0)
+		assert.NoError(t, m.UpdateState(ctx, triaged[0].Key, SPAM_STATE))
+		page = `<article class="h-entry"><h1 class="p-name">Second Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.Equal(t, SPAM_STATE, all[0].State)
+		assert.Equal(t, "Second Title", all[0].Title)
+		assert.False(t, all[0].Reverify)
+		assert.Len(t, m.GetQueued(ctx), 0)
+
+		// Restore the page for the next store.
+		page = `<article class="h-entry"><h1 class="p-name">First Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+	})
+}
+
+func TestVerifyQueuedMentions_ReverifyKeepsPublished(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<article class="h-entry"><h1 class="p-name">No date</h1><a href="https://bitworking.org/bar">link</a></article>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		good := m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		published := good[0].Published
+		assert.False(t, published.IsZero())
+
+		// Neither a scheduled re-verify nor a re-send changes it.
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, m.QueueReverify(ctx))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		good = m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		assert.False(t, good[0].Reverify)
+		assert.True(t, published.Equal(good[0].Published), "%s != %s", published, good[0].Published)
+	})
+}
+
+func TestVerifyQueuedMentions_Deleted(t *testing.T) {
+	status := http.StatusOK
+	page := `<article class="h-entry"><h1 class="p-name">Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(page))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		status = http.StatusOK
+		page = `<article class="h-entry"><h1 class="p-name">Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+
+		gone := New(ts.URL+"/gone", "https://bitworking.org/bar")
+		unlinked := New(ts.URL+"/unlinked", "https://bitworking.org/bar")
+		assert.NoError(t, m.Put(ctx, gone))
+		assert.NoError(t, m.Put(ctx, unlinked))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 2)
+
+		// The source now returns 410 Gone.
+		status = http.StatusGone
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/gone", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		good := m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		assert.Equal(t, unlinked.Source, good[0].Source)
+
+		// The source no longer links to the target.
+		status = http.StatusOK
+		page = `<article class="h-entry"><h1 class="p-name">Title</h1></article>`
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/unlinked", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 0)
+
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 2)
+		for _, mention := range all {
+			assert.Equal(t, DELETED_STATE, mention.State)
+			assert.Equal(t, "", mention.Title)
+		}
+
+		// A new mention that doesn't link is still spam.
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/new", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		assert.Len(t, m.GetAll(ctx, "https://bitworking.org/bar"), 3)
+		assert.Equal(t, SPAM_STATE, m.GetTriage(ctx, 1, 0)[0].State)
+
+		// Restoring the link restores the mention.
+		page = `<article class="h-entry"><h1 class="p-name">Title</h1><a href="https://bitworking.org/bar">link</a></article>`
+		assert.NoError(t, m.Put(ctx, New(ts.URL+"/unlinked", "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		good = m.GetGood(ctx, "https://bitworking.org/bar")
+		assert.Len(t, good, 1)
+		assert.Equal(t, "Title", good[0].Title)
+	})
+}
+
+func TestVerifyQueuedMentions_DeletedKeepsModeration(t *testing.T) {
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(`<article class="h-entry"><h1 class="p-name">Title</h1><a href="https://bitworking.org/bar">link</a></article>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		status = http.StatusOK
+		source := ts.URL + "/spam"
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		key := New(source, "https://bitworking.org/bar").Key()
+		assert.NoError(t, m.UpdateState(ctx, key, SPAM_STATE))
+
+		// The source is deleted.
+		status = http.StatusGone
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.Equal(t, DELETED_STATE, all[0].State)
+
+		// The source is restored, and it's still spam.
+		status = http.StatusOK
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		all = m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.Equal(t, SPAM_STATE, all[0].State)
+		assert.Equal(t, "Title", all[0].Title)
+		assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 0)
+
+		// A deletion made by a moderator is kept too.
+		assert.NoError(t, m.UpdateState(ctx, key, DELETED_STATE))
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		assert.Equal(t, DELETED_STATE, m.GetAll(ctx, "https://bitworking.org/bar")[0].State)
+	})
+}
+
+func TestVerifyQueuedMentions_ModerationDuringVerifyIsKept(t *testing.T) {
+	var moderate func()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The moderator acts while the source is being fetched.
+		moderate()
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<article class="h-entry"><h1 class="p-name">Title</h1><a href="https://bitworking.org/bar">link</a></article>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		source := ts.URL + "/reply"
+		key := New(source, "https://bitworking.org/bar").Key()
+		moderate = func() {
+			assert.NoError(t, m.UpdateState(ctx, key, SPAM_STATE))
+		}
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.Equal(t, SPAM_STATE, all[0].State)
+		assert.True(t, all[0].Moderated)
+		assert.Equal(t, "Title", all[0].Title)
+
+		// A mention that was already moderated gets the new decision too.
+		moderate = func() {
+			assert.NoError(t, m.UpdateState(ctx, key, GOOD_STATE))
+		}
+		assert.NoError(t, m.Put(ctx, New(source, "https://bitworking.org/bar")))
+		m.VerifyQueuedMentions(ctx, ts.Client())
+		all = m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.Equal(t, GOOD_STATE, all[0].State)
+		assert.False(t, all[0].Reverify)
+	})
+}
+
+func TestQueueReverify(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		for _, state := range []string{GOOD_STATE, SPAM_STATE, DELETED_STATE} {
+			mention := New("https://example.com/"+state, "https://bitworking.org/bar")
+			mention.State = state
+			assert.NoError(t, m.Put(ctx, mention))
+		}
+		assert.Len(t, m.GetQueued(ctx), 0)
+		assert.NoError(t, m.QueueReverify(ctx))
+		queued := m.GetQueued(ctx)
+		assert.Len(t, queued, 1)
+		assert.Equal(t, GOOD_STATE, queued[0].State)
+	})
+}
+
+func TestCleanup(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		old := time.Now().Add(-48 * time.Hour)
+		put := func(name, state string, f func(*Mention)) {
+			mention := New("https://example.com/"+name, "https://bitworking.org/bar")
+			mention.State = state
+			f(mention)
+			assert.NoError(t, m.Put(ctx, mention))
+		}
+		for _, state := range []string{GOOD_STATE, SPAM_STATE, DELETED_STATE, UNTRIAGED_STATE} {
+			// Changed to the state long ago.
+			put("old/"+state, state, func(mention *Mention) {
+				mention.TS = old
+				mention.StateChanged = old
+				mention.LastError = "Failed"
+			})
+			// Received long ago, but only changed to the state recently.
+			put("recent/"+state, state, func(mention *Mention) {
+				mention.TS = old
+				mention.StateChanged = time.Now()
+				mention.LastError = "Failed"
+			})
+			// Set by a moderator long ago.
+			put("moderated/"+state, state, func(mention *Mention) {
+				mention.TS = old
+				mention.StateChanged = old
+				mention.Moderated = true
+			})
+		}
+		// Mentions from before StateChanged was recorded.
+		put("legacy/failed", SPAM_STATE, func(mention *Mention) {
+			mention.TS = old
+			mention.LastError = "Failed"
+		})
+		put("legacy/resent", DELETED_STATE, func(mention *Mention) {
+			mention.TS = old
+			mention.Updated = time.Now()
+			mention.LastError = "Failed"
+		})
+		put("legacy/moderated", SPAM_STATE, func(mention *Mention) {
+			mention.TS = old
+		})
+		assert.NoError(t, m.Cleanup(ctx, 24*time.Hour))
+
+		remaining := map[string]bool{}
+		for _, mention := range m.GetAll(ctx, "https://bitworking.org/bar") {
+			remaining[strings.TrimPrefix(mention.Source, "https://example.com/")] = true
+		}
+		assert.Equal(t, map[string]bool{
+			"old/good":            true,
+			"old/untriaged":       true,
+			"recent/good":         true,
+			"recent/spam":         true,
+			"recent/deleted":      true,
+			"recent/untriaged":    true,
+			"moderated/good":      true,
+			"moderated/spam":      true,
+			"moderated/deleted":   true,
+			"moderated/untriaged": true,
+			"legacy/resent":       true,
+			"legacy/moderated":    true,
+		}, remaining)
+	})
+}
+
+func TestUpdateState_RecordsModeration(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		mention := New("https://example.com/", "https://bitworking.org/bar")
+		assert.NoError(t, m.Put(ctx, mention))
+		assert.NoError(t, m.UpdateState(ctx, mention.Key(), SPAM_STATE))
+		all := m.GetAll(ctx, "https://bitworking.org/bar")
+		assert.Len(t, all, 1)
+		assert.True(t, all[0].Moderated)
+		assert.False(t, all[0].StateChanged.IsZero())
+	})
 }
 
 func TestParseMicroformats(t *testing.T) {
@@ -112,26 +610,39 @@ func TestParseMicroformats(t *testing.T) {
 	<div id="mentions"></div>
 </article>`
 
-	m := InitForTesting(t)
-
-	reader := bytes.NewReader([]byte(raw))
-	u, err := url.Parse("https://bitworking.org/news/2018/01/webmention-only-2")
+	// The zone name of Published comes from the local time zone, if its
+	// offset matches.
+	local := time.Local
+	defer func() { time.Local = local }()
+	nyc, err := time.LoadLocation("America/New_York")
 	assert.NoError(t, err)
-	data := microformats.Parse(reader, u)
-	// VerifyQueuedMentions will start with Source == URL.
-	mention := &Mention{
-		Source: "https://bitworking.org/news/2018/01/webmention-only-2",
-		URL:    "https://bitworking.org/news/2018/01/webmention-only-2",
-	}
-	urlToImageReader := func(url string) (io.ReadCloser, error) {
-		return os.Open("./testdata/author_image.jpg")
-	}
-	m.findHEntry(context.Background(), urlToImageReader, mention, data, data.Items)
-	assert.Equal(t, "Joe Gregorio", mention.Author)
-	assert.Equal(t, "2018-01-13 00:00:00 -0500 EST", mention.Published.String())
-	assert.Equal(t, "f3f799d1a61805b5ee2ccb5cf0aebafa", mention.Thumbnail)
-	assert.Equal(t, "https://bitworking.org/about", mention.AuthorURL)
-	assert.Equal(t, "https://bitworking.org/news/2018/01/webmention-only-2", mention.URL)
+	time.Local = nyc
+
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+
+		reader := bytes.NewReader([]byte(raw))
+		u, err := url.Parse("https://bitworking.org/news/2018/01/webmention-only-2")
+		assert.NoError(t, err)
+		data := microformats.Parse(reader, u)
+		// VerifyQueuedMentions will start with Source == URL.
+		mention := &Mention{
+			Source: "https://bitworking.org/news/2018/01/webmention-only-2",
+			URL:    "https://bitworking.org/news/2018/01/webmention-only-2",
+		}
+		urlToImageReader := func(ctx context.Context, url string) (io.ReadCloser, error) {
+			return os.Open("./testdata/author_image.jpg")
+		}
+		m.findHEntry(context.Background(), urlToImageReader, nil, mention, data, data.Items)
+		assert.Equal(t, "Joe Gregorio", mention.Author)
+		assert.Equal(t, "2018-01-13 00:00:00 -0500 EST", mention.Published.String())
+		assert.Equal(t, "b7c361dba517e2c9d4107c95f4f3edb7", mention.Thumbnail)
+		assertThumbnail(t, m, mention.Thumbnail)
+		assert.Equal(t, "https://bitworking.org/about", mention.AuthorURL)
+		assert.Equal(t, "https://bitworking.org/news/2018/01/webmention-only-2", mention.URL)
+		assert.Equal(t, MENTION_TYPE, mention.Type)
+		assert.True(t, strings.HasPrefix(mention.ContentHTML, `<p><a href="https://allinthehead.com/retro/378/implementing-webmentions" rel="nofollow ugc">Drew McLellan has gone WebMention-only.</a></p>`))
+		assert.True(t, strings.HasPrefix(mention.ContentText, "Drew McLellan has gone WebMention-only. It’s an interesting idea"))
+	})
 }
 
 func TestParseMicroformatsBridgy(t *testing.T) {
@@ -165,24 +676,65 @@ func TestParseMicroformatsBridgy(t *testing.T) {
 </article>
 </html> `
 
-	m := InitForTesting(t)
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+
+		reader := bytes.NewReader([]byte(raw))
+		u, err := url.Parse("https://bitworking.org/news/2018/01/webmention-only")
+		assert.NoError(t, err)
+		data := microformats.Parse(reader, u)
+		mention := &Mention{
+			Source: "https://bitworking.org/news/2018/01/webmention-only",
+			Target: "https://bitworking.org/news/2019/05/webmention-on-google-cloud-run",
+		}
+		urlToImageReader := func(ctx context.Context, url string) (io.ReadCloser, error) {
+			return os.Open("./testdata/author_image.jpg")
+		}
+		m.findHEntry(context.Background(), urlToImageReader, nil, mention, data, data.Items)
+		assert.Equal(t, "Some Body", mention.Author)
+		assert.Equal(t, "Twitter", mention.Title)
+		assert.Equal(t, LIKE_TYPE, mention.Type)
+		assert.Equal(t, "b7c361dba517e2c9d4107c95f4f3edb7", mention.Thumbnail)
+		assertThumbnail(t, m, mention.Thumbnail)
+		assert.Equal(t, "https://twitter.com/somebody", mention.AuthorURL)
+		assert.Equal(t, "https://twitter.com/bitworking/status/1125545560939933697#favorited-by-8855932", mention.URL)
+	})
+}
 
-	reader := bytes.NewReader([]byte(raw))
-	u, err := url.Parse("https://bitworking.org/news/2018/01/webmention-only")
+func TestFindType(t *testing.T) {
+	u, err := url.Parse("https://example.com/post")
 	assert.NoError(t, err)
-	data := microformats.Parse(reader, u)
-	mention := &Mention{
-		Source: "https://bitworking.org/news/2018/01/webmention-only",
-	}
-	urlToImageReader := func(url string) (io.ReadCloser, error) {
-		return os.Open("./testdata/author_image.jpg")
+	for class, expected := range map[string]string{
+		`<a class="u-in-reply-to" href="https://bitworking.org/bar">`:                                  REPLY_TYPE,
+		`<a class="u-like-of" href="https://bitworking.org/bar">`:                                      LIKE_TYPE,
+		`<a class="u-repost-of" href="https://bitworking.org/bar">`:                                    REPOST_TYPE,
+		`<a class="u-bookmark-of" href="https://bitworking.org/bar">`:                                  BOOKMARK_TYPE,
+		`<a class="u-in-reply-to" href="https://bitworking.org/bar"><data class="p-rsvp" value="yes">`: RSVP_TYPE,
+		`<a href="https://bitworking.org/bar">`:                                                        MENTION_TYPE,
+
+		// Only properties that refer to the target count.
+		`<a class="u-like-of" href="https://example.org/other"></a><a href="https://bitworking.org/bar">`:                         MENTION_TYPE,
+		`<a class="u-in-reply-to" href="https://example.org/other"></a><a href="https://bitworking.org/bar">`:                     MENTION_TYPE,
+		`<a class="u-like-of" href="https://example.org/other"></a><a class="u-repost-of" href="http://www.bitworking.org/bar/">`: REPOST_TYPE,
+		`<div class="u-in-reply-to h-cite"><a class="u-url" href="https://bitworking.org/bar">Bar</a></div><a>`:                   REPLY_TYPE,
+	} {
+		raw := `<div class="h-entry"><p class="p-name">Title</p>` + class + `link</a></div>`
+		data := microformats.Parse(strings.NewReader(raw), u)
+		assert.Len(t, data.Items, 1)
+		assert.Equal(t, expected, findType(data.Items[0], "https://bitworking.org/bar"), class)
 	}
-	m.findHEntry(context.Background(), urlToImageReader, mention, data, data.Items)
-	assert.Equal(t, "Some Body", mention.Author)
-	assert.Equal(t, "Twitter Like", mention.Title)
-	assert.Equal(t, "f3f799d1a61805b5ee2ccb5cf0aebafa", mention.Thumbnail)
-	assert.Equal(t, "https://twitter.com/somebody", mention.AuthorURL)
-	assert.Equal(t, "https://twitter.com/bitworking/status/1125545560939933697#favorited-by-8855932", mention.URL)
+}
+
+func TestPropURLs(t *testing.T) {
+	u, err := url.Parse("https://example.com/post")
+	assert.NoError(t, err)
+	raw := `<div class="h-entry"><p class="p-name">Title</p>
+	<a class="u-in-reply-to" href="https://bitworking.org/bar">link</a>
+	<div class="u-in-reply-to h-cite"><a class="u-url" href="https://example.org/reply">Reply</a></div>
+	</div>`
+	data := microformats.Parse(strings.NewReader(raw), u)
+	assert.Len(t, data.Items, 1)
+	assert.Equal(t, []string{"https://bitworking.org/bar", "https://example.org/reply"}, propURLs(data.Items[0], "in-reply-to"))
+	assert.Len(t, propURLs(data.Items[0], "like-of"), 0)
 }
 
 func TestFastValidate(t *testing.T) {
@@ -193,4 +745,10 @@ func TestFastValidate(t *testing.T) {
 	m = New("https://example.com", "https://stream.bitworking.org")
 	assert.NoError(t, m.FastValidate([]string{"bitworking.org", "stream.bitworking.org"}))
 	assert.Error(t, m.FastValidate([]string{"random-subdomain.bitworking.org"}))
+
+	// TARGETS are compared the same way targets are canonicalized.
+	m = New("https://example.com", "https://www.bitworking.org/news/a")
+	assert.NoError(t, m.FastValidate([]string{"bitworking.org"}))
+	m = New("https://example.com", "https://bitworking.org/news/a")
+	assert.NoError(t, m.FastValidate([]string{"www.bitworking.org"}))
 }
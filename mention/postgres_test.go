@@ -0,0 +1,121 @@
+package mention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPostgresSchemaForTesting creates a fresh schema in the database in
+// POSTGRES_TEST_URL, see `make start_postgres`, and returns a dsn that uses
+// it, along with a func that drops that schema.
+func newPostgresSchemaForTesting(t *testing.T) (string, func()) {
+	dsn := os.Getenv("POSTGRES_TEST_URL")
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	schema := fmt.Sprintf("test_%d", r.Uint32())
+
+	admin, err := sql.Open("postgres", dsn)
+	assert.NoError(t, err)
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(dsn)
+	assert.NoError(t, err)
+	values := u.Query()
+	values.Set("search_path", schema)
+	u.RawQuery = values.Encode()
+
+	return u.String(), func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		assert.NoError(t, err)
+		admin.Close()
+	}
+}
+
+// newPostgresStoreForTesting returns a PostgresStore that lives in a fresh
+// schema, along with a func that drops that schema.
+func newPostgresStoreForTesting(t *testing.T) (*PostgresStore, func()) {
+	dsn, drop := newPostgresSchemaForTesting(t)
+	s, err := NewPostgresStore(context.Background(), dsn)
+	assert.NoError(t, err)
+	return s, func() {
+		s.Close()
+		drop()
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	if os.Getenv("POSTGRES_TEST_URL") == "" {
+		t.Skip("POSTGRES_TEST_URL not set, see `make start_postgres`.")
+	}
+	s, cleanup := newPostgresStoreForTesting(t)
+	defer cleanup()
+
+	testStore(t, s)
+}
+
+func TestPostgresStore_MigrateIsIdempotent(t *testing.T) {
+	if os.Getenv("POSTGRES_TEST_URL") == "" {
+		t.Skip("POSTGRES_TEST_URL not set, see `make start_postgres`.")
+	}
+	s, cleanup := newPostgresStoreForTesting(t)
+	defer cleanup()
+
+	assert.NoError(t, s.migrate(context.Background()))
+	var version int
+	assert.NoError(t, s.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version))
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestPostgresStore_MigrationKeepsSentHistory(t *testing.T) {
+	if os.Getenv("POSTGRES_TEST_URL") == "" {
+		t.Skip("POSTGRES_TEST_URL not set, see `make start_postgres`.")
+	}
+	dsn, drop := newPostgresSchemaForTesting(t)
+	defer drop()
+
+	// Set up the schema from before sent webmentions were tracked per target.
+	db, err := sql.Open("postgres", dsn)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied TIMESTAMPTZ NOT NULL)")
+	assert.NoError(t, err)
+	for i, migration := range migrations[:3] {
+		_, err = db.Exec(migration)
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT INTO schema_migrations (version, applied) VALUES ($1, $2)", i+1, time.Now())
+		assert.NoError(t, err)
+	}
+	ts := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	_, err = db.Exec("INSERT INTO web_mention_sent (source, ts) VALUES ($1, $2)", "https://bitworking.org/news/first", ts)
+	assert.NoError(t, err)
+
+	s, err := NewPostgresStore(context.Background(), dsn)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// The old row is kept under the key it would have in the other stores,
+	// and like them it isn't returned as a delivery.
+	old := &WebMentionSent{Source: "https://bitworking.org/news/first"}
+	var source string
+	var sentTS time.Time
+	assert.NoError(t, s.db.QueryRow("SELECT source, ts FROM web_mention_sent WHERE key = $1", old.Key()).Scan(&source, &sentTS))
+	assert.Equal(t, old.Source, source)
+	assert.True(t, ts.Equal(sentTS))
+	sent, err := s.QuerySent(context.Background(), &SentQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 0)
+
+	// New records are stored alongside it.
+	assert.NoError(t, s.PutSent(context.Background(), "key", &WebMentionSent{Source: old.Source, Target: "https://example.com/", TS: ts}))
+	sent, err = s.QuerySent(context.Background(), &SentQuery{Source: old.Source})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 1)
+}
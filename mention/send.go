@@ -0,0 +1,461 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"willnorris.com/go/microformats"
+	"willnorris.com/go/webmention"
+
+	"github.com/jcgregorio/webmention-run/feed"
+	"github.com/jcgregorio/webmention-run/safehttp"
+)
+
+// CONTENT_SELECTOR selects the part of our own posts whose links are sent
+// webmentions.
+const CONTENT_SELECTOR = ".h-entry .e-content"
+
+// errGone is returned from fetch when the page has been deleted, i.e. it
+// responds with a 410.
+var errGone = errors.New("Gone")
+
+// SendReport is the outcome of sending webmentions for one of our posts.
+type SendReport struct {
+	Source string `json:"source"`
+
+	// Updated is when the post was last updated, or published if it has never
+	// been updated.
+	Updated time.Time `json:"updated"`
+
+	// Skipped is true if nothing was sent because the post hasn't changed
+	// since webmentions were last sent for it.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Deleted is true if the post has been deleted, in which case webmentions
+	// are sent to all the targets that were previously notified.
+	Deleted bool `json:"deleted,omitempty"`
+
+	Results []*WebMentionSent `json:"results"`
+}
+
+// postInfo is what Send needs to know about one of our posts.
+type postInfo struct {
+	updated time.Time
+	links   []string
+}
+
+// findPostTime returns the dt-updated, or else dt-published, of the first
+// h-entry in items.
+func findPostTime(items []*microformats.Microformat) (time.Time, bool) {
+	for _, it := range items {
+		if in("h-entry", it.Type) {
+			for _, prop := range []string{"updated", "published"} {
+				if t, err := time.Parse(time.RFC3339, firstPropAsString(it, prop)); err == nil {
+					return t, true
+				}
+			}
+			return time.Time{}, true
+		}
+		if t, ok := findPostTime(it.Children); ok {
+			return t, ok
+		}
+	}
+	return time.Time{}, false
+}
+
+// parsePost finds the time and outbound links of the post at source, whose
+// content is read from r.
+func parsePost(r io.Reader, source string) (*postInfo, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid source URL: %s", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	updated, ok := findPostTime(microformats.Parse(bytes.NewReader(b), u).Items)
+	if !ok {
+		return nil, fmt.Errorf("No h-entry found in %q.", source)
+	}
+	links, err := findLinks(bytes.NewReader(b), source, CONTENT_SELECTOR)
+	if err != nil {
+		return nil, err
+	}
+	return &postInfo{
+		updated: updated,
+		links:   links,
+	}, nil
+}
+
+// findLinks returns the http and https links in the HTML read from r, within
+// the elements matching selector, without duplicates or links back to
+// source.
+func findLinks(r io.Reader, source, selector string) ([]string, error) {
+	links, err := webmention.DiscoverLinksFromReader(r, source, selector)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to discover links: %s", err)
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, link := range links {
+		target, err := url.Parse(link)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			continue
+		}
+		target.Fragment = ""
+		link = target.String()
+		if link == source || seen[link] {
+			continue
+		}
+		seen[link] = true
+		ret = append(ret, link)
+	}
+	return ret, nil
+}
+
+// fetch returns the body of the page at u, which must respond with a 200, or
+// errGone if it responds with a 410.
+func (m *Mentions) fetch(ctx context.Context, u string, c *http.Client) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build request: %s", err)
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve %q: %s", u, err)
+	}
+	defer m.close(resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return nil, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to retrieve %q: %d", u, resp.StatusCode)
+	}
+	b, err := readLimited(resp.Body, m.MaxSourceBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %q: %s", u, err)
+	}
+	return b, nil
+}
+
+// Send sends webmentions from our post at source to every page it links to
+// from within its h-entry e-content.
+//
+// The delivery to each target is recorded in a WebMentionSent along with the
+// time the post was last updated, and if the post hasn't been updated since
+// then nothing is sent, unless force is true. Deliveries that fail for reasons
+// that may pass are retried later by RetrySent.
+//
+// When the post is updated, webmentions are also sent to every target that
+// was previously notified but is no longer linked to, so it can find out the
+// link was removed. When the post is deleted, i.e. source responds with a 410,
+// every target that was previously notified is sent a webmention once.
+func (m *Mentions) Send(ctx context.Context, source string, force bool, c *http.Client) (*SendReport, error) {
+	previous, err := m.store.QuerySent(ctx, &SentQuery{Source: source})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find sent webmentions for %q: %s", source, err)
+	}
+	last, err := m.lastSent(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.fetch(ctx, source, c)
+	if err == errGone {
+		return m.sendDeleted(ctx, source, previous, force, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	post, err := parsePost(bytes.NewReader(b), source)
+	if err != nil {
+		return nil, err
+	}
+	report := &SendReport{
+		Source:  source,
+		Updated: post.updated,
+		Results: []*WebMentionSent{},
+	}
+	if !force && !m.changed(source, last, previous, post.updated) {
+		report.Skipped = true
+		return report, nil
+	}
+	return report, m.sendAll(ctx, report, withNotified(post.links, previous), previous, c)
+}
+
+// sendDeleted sends webmentions for the deleted post at source to all the
+// previously notified targets that haven't already been told of the
+// deletion, or all of them if force is true.
+func (m *Mentions) sendDeleted(ctx context.Context, source string, previous []*WebMentionSent, force bool, c *http.Client) (*SendReport, error) {
+	report := &SendReport{
+		Source:  source,
+		Deleted: true,
+		Results: []*WebMentionSent{},
+	}
+	targets := []string{}
+	for _, sent := range previous {
+		if sent.WasNotified() && (force || !sent.Deleted) {
+			targets = append(targets, sent.Target)
+		}
+	}
+	if len(targets) == 0 {
+		m.log.Infof("Not sending webmentions for deleted %q, no targets left to notify.", source)
+		report.Skipped = true
+		return report, nil
+	}
+	return report, m.sendAll(ctx, report, targets, previous, c)
+}
+
+// lastSent returns the SentSource of source, or nil if webmentions have never
+// been sent from it.
+func (m *Mentions) lastSent(ctx context.Context, source string) (*SentSource, error) {
+	last, err := m.store.GetSentSource(ctx, (&SentSource{Source: source}).Key())
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find when %q was last sent: %s", source, err)
+	}
+	return last, nil
+}
+
+// changed returns true if source has been updated since webmentions were
+// last sent for it, given its SentSource, which may be nil, and the previous
+// WebMentionSent records for source. A post that was deleted and has come
+// back has always changed.
+func (m *Mentions) changed(source string, last *SentSource, previous []*WebMentionSent, updated time.Time) bool {
+	if last != nil && last.Deleted {
+		return true
+	}
+	for _, s := range previous {
+		if s.Deleted {
+			return true
+		}
+	}
+	if last != nil && !updated.After(last.Updated) {
+		m.log.Infof("Not sending webmentions for %q, unchanged since %s.", source, last.Updated)
+		return false
+	}
+	for _, s := range previous {
+		if !updated.After(s.Updated) {
+			m.log.Infof("Not sending webmentions for %q, unchanged since %s.", source, s.Updated)
+			return false
+		}
+	}
+	return true
+}
+
+// withNotified returns links along with the targets of the previous
+// WebMentionSent records that were notified, but are no longer in links.
+func withNotified(links []string, previous []*WebMentionSent) []string {
+	ret := append([]string{}, links...)
+	seen := map[string]bool{}
+	for _, link := range links {
+		seen[link] = true
+	}
+	for _, sent := range previous {
+		if sent.WasNotified() && !seen[sent.Target] {
+			seen[sent.Target] = true
+			ret = append(ret, sent.Target)
+		}
+	}
+	return ret
+}
+
+// sendAll sends webmentions from report.Source to each of the links, recording
+// each delivery and adding it to the report. The previous WebMentionSent
+// records for the source are used to remember which targets were notified.
+func (m *Mentions) sendAll(ctx context.Context, report *SendReport, links []string, previous []*WebMentionSent, c *http.Client) error {
+	notified := map[string]bool{}
+	for _, sent := range previous {
+		if sent.WasNotified() {
+			notified[sent.Target] = true
+		}
+	}
+	client := webmention.New(c)
+	for _, target := range links {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sent := &WebMentionSent{
+			Source:   report.Source,
+			Target:   target,
+			Updated:  report.Updated.UTC(),
+			Deleted:  report.Deleted,
+			Notified: notified[target],
+		}
+		m.sendOne(client, sent)
+		if err := m.store.PutSent(ctx, sent.Key(), sent); err != nil {
+			return fmt.Errorf("Failed to record sent: %s", err)
+		}
+		report.Results = append(report.Results, sent)
+	}
+	// Record that the source was sent as of report.Updated even if it has no
+	// links, so it isn't sent again until it changes.
+	last := &SentSource{
+		Source:  report.Source,
+		TS:      time.Now().UTC(),
+		Updated: report.Updated.UTC(),
+		Deleted: report.Deleted,
+	}
+	if err := m.store.PutSentSource(ctx, last.Key(), last); err != nil {
+		return fmt.Errorf("Failed to record sent source: %s", err)
+	}
+	return nil
+}
+
+// RetrySent retries the failed deliveries whose next attempt is due.
+func (m *Mentions) RetrySent(ctx context.Context, c *http.Client) error {
+	sent, err := m.store.QuerySent(ctx, &SentQuery{Retry: true})
+	if err != nil {
+		return fmt.Errorf("Failed to find failed deliveries: %s", err)
+	}
+	client := webmention.New(c)
+	now := time.Now()
+	for _, s := range sent {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if s.NextAttempt.After(now) {
+			continue
+		}
+		m.sendOne(client, s)
+		if err := m.store.PutSent(ctx, s.Key(), s); err != nil {
+			return fmt.Errorf("Failed to record sent: %s", err)
+		}
+	}
+	return nil
+}
+
+// SentDeliveries returns the records of sent webmentions, most recently sent
+// first.
+func (m *Mentions) SentDeliveries(ctx context.Context, limit, offset int) ([]*WebMentionSent, error) {
+	return m.store.QuerySent(ctx, &SentQuery{
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// entryLinks returns the links in the feed entry's content, or if the feed
+// doesn't include content, in the h-entry e-content of the entry's page.
+func (m *Mentions) entryLinks(ctx context.Context, entry *feed.Entry, c *http.Client) ([]string, error) {
+	if entry.Content != "" {
+		return findLinks(strings.NewReader(entry.Content), entry.URL, "")
+	}
+	b, err := m.fetch(ctx, entry.URL, c)
+	if err != nil {
+		return nil, err
+	}
+	post, err := parsePost(bytes.NewReader(b), entry.URL)
+	if err != nil {
+		return nil, err
+	}
+	return post.links, nil
+}
+
+// SendFeed sends webmentions for every entry in the feed at feedURL that is
+// new, or has been updated, since webmentions were last sent for it. The feed
+// may be RSS, Atom, JSON Feed, or an HTML page with an h-feed.
+func (m *Mentions) SendFeed(ctx context.Context, feedURL string, c *http.Client) ([]*SendReport, error) {
+	b, err := m.fetch(ctx, feedURL, c)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := feed.Parse(b, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	ret := []*SendReport{}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
+		previous, err := m.store.QuerySent(ctx, &SentQuery{Source: entry.URL})
+		if err != nil {
+			return ret, fmt.Errorf("Failed to find sent webmentions for %q: %s", entry.URL, err)
+		}
+		last, err := m.lastSent(ctx, entry.URL)
+		if err != nil {
+			return ret, err
+		}
+		if !m.changed(entry.URL, last, previous, entry.Updated) {
+			continue
+		}
+		report := &SendReport{
+			Source:  entry.URL,
+			Updated: entry.Updated,
+			Results: []*WebMentionSent{},
+		}
+		links, err := m.entryLinks(ctx, entry, c)
+		if err != nil {
+			m.log.Warningf("Failed to find links in %q from %q: %s", entry.URL, feedURL, err)
+			continue
+		}
+		if err := m.sendAll(ctx, report, withNotified(links, previous), previous, c); err != nil {
+			return ret, err
+		}
+		ret = append(ret, report)
+	}
+	return ret, nil
+}
+
+// sendOne discovers the webmention endpoint of sent.Target and, if it has one,
+// sends it a webmention from sent.Source, updating sent with the outcome.
+func (m *Mentions) sendOne(client *webmention.Client, sent *WebMentionSent) {
+	sent.TS = time.Now().UTC()
+	sent.Attempts++
+	sent.Notified = sent.WasNotified()
+	sent.Endpoint = ""
+	sent.Status = 0
+	sent.Location = ""
+	sent.LastError = ""
+	sent.NextAttempt = time.Time{}
+
+	endpoint, err := client.DiscoverEndpoint(sent.Target)
+	if err != nil {
+		m.log.Infof("Failed to discover endpoint of %q: %s", sent.Target, err)
+		m.sendFailed(sent, fmt.Sprintf("Failed to discover endpoint: %s", err), !safehttp.IsRejected(err))
+		return
+	}
+	if endpoint == "" {
+		m.log.Infof("No webmention endpoint for %q.", sent.Target)
+		return
+	}
+	sent.Endpoint = endpoint
+	sent.Notified = true
+	resp, err := client.SendWebmention(endpoint, sent.Source, sent.Target)
+	if resp != nil {
+		sent.Status = resp.StatusCode
+		if loc, err := resp.Location(); err == nil {
+			sent.Location = loc.String()
+		}
+		m.close(resp.Body)
+	}
+	if err != nil {
+		m.log.Infof("Failed to send webmention from %q to %q via %q: %s", sent.Source, sent.Target, endpoint, err)
+		transient := isTransientStatus(sent.Status)
+		if resp == nil {
+			transient = !safehttp.IsRejected(err)
+		}
+		m.sendFailed(sent, fmt.Sprintf("Failed to send webmention: %s", err), transient)
+		return
+	}
+	m.log.Infof("Sent webmention from %q to %q via %q.", sent.Source, sent.Target, endpoint)
+}
+
+// sendFailed records why delivery failed, and if the failure may pass and
+// there are attempts left, schedules a retry.
+func (m *Mentions) sendFailed(sent *WebMentionSent, reason string, transient bool) {
+	sent.LastError = reason
+	if transient && sent.Attempts < m.SendMaxAttempts {
+		sent.NextAttempt = time.Now().Add(m.backoff(sent.Attempts)).UTC()
+	}
+}
@@ -0,0 +1,380 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// site is a test server that hosts one of our posts, and the pages it links
+// to along with their webmention endpoints.
+type site struct {
+	ts *httptest.Server
+
+	mutex    sync.Mutex
+	updated  string
+	failing  bool
+	removed  bool
+	gone     bool
+	broken   bool
+	received map[string]int
+}
+
+func newSite(t *testing.T) *site {
+	s := &site{
+		updated:  "2019-05-01T00:00:00Z",
+		received: map[string]int{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.gone {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		html := `<a href="/html">Endpoint in HTML</a>`
+		if s.removed {
+			html = ""
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, err := fmt.Fprintf(w, `<article class="h-entry">
+			<a href="/other">Not in the content.</a>
+			<time class="dt-published" datetime="2019-04-01T00:00:00Z"></time>
+			<time class="dt-updated" datetime="%s"></time>
+			<div class="e-content">
+				<a href="/header">Endpoint in header</a>
+				<a href="/header#fragment">Same again</a>
+				%s
+				<a href="/none">No endpoint</a>
+				<a href="/failing">Failing endpoint</a>
+				<a href="/post">Self</a>
+				<a href="mailto:someone@example.com">Email</a>
+			</div>
+		</article>`, s.updated, html)
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/feed+json")
+		_, err := fmt.Fprintf(w, `{
+			"version": "https://jsonfeed.org/version/1",
+			"items": [
+				{"url": "/post", "date_modified": %q},
+				{"url": "/second", "date_published": "2019-04-01T00:00:00Z", "content_html": "<a href=\"/html\">link</a>"},
+				{"url": "/third", "date_published": "2019-03-01T00:00:00Z", "content_html": "<p>No links.</p>"}
+			]
+		}`, s.updated)
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.broken {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Link", `</endpoint>; rel="webmention"`)
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<html><head><link rel="webmention" href="/endpoint"></head></html>`))
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	})
+	mux.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</failing-endpoint>; rel="webmention"`)
+	})
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Links outside the e-content should not be sent webmentions.")
+	})
+	mux.HandleFunc("/endpoint", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		assert.True(t, strings.HasPrefix(r.FormValue("source"), s.ts.URL+"/"))
+		s.received[r.FormValue("target")]++
+		w.Header().Set("Location", "/status/1")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/failing-endpoint", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.received[r.FormValue("target")]++
+	})
+	s.ts = httptest.NewServer(mux)
+	return s
+}
+
+func (s *site) count(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.received[s.ts.URL+path]
+}
+
+func TestSend(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		s := newSite(t)
+		defer s.ts.Close()
+		s.failing = true
+		m.VerifyBackoff = 0
+		ctx := context.Background()
+		source := s.ts.URL + "/post"
+
+		report, err := m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.False(t, report.Skipped)
+		assert.Equal(t, "2019-05-01T00:00:00Z", report.Updated.Format(time.RFC3339))
+		assert.Len(t, report.Results, 4)
+		assert.Equal(t, s.ts.URL+"/endpoint", report.Results[0].Endpoint)
+		assert.Equal(t, http.StatusAccepted, report.Results[0].Status)
+		assert.Equal(t, s.ts.URL+"/status/1", report.Results[0].Location)
+		assert.Equal(t, "", report.Results[2].Endpoint)
+		assert.Equal(t, "", report.Results[2].LastError)
+		assert.Equal(t, http.StatusInternalServerError, report.Results[3].Status)
+		assert.True(t, strings.HasPrefix(report.Results[3].LastError, "Failed to send webmention"))
+		assert.False(t, report.Results[3].NextAttempt.IsZero())
+		assert.Equal(t, 1, s.count("/header"))
+		assert.Equal(t, 1, s.count("/html"))
+
+		// Every delivery is recorded.
+		sent, err := m.SentDeliveries(ctx, 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, sent, 4)
+
+		// Unchanged posts aren't sent again.
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.True(t, report.Skipped)
+		assert.Len(t, report.Results, 0)
+		assert.Equal(t, 1, s.count("/header"))
+
+		// But failed deliveries are retried.
+		s.mutex.Lock()
+		s.failing = false
+		s.mutex.Unlock()
+		assert.NoError(t, m.RetrySent(ctx, s.ts.Client()))
+		assert.Equal(t, 1, s.count("/header"))
+		assert.Equal(t, 1, s.count("/failing"))
+		sent, err = m.store.QuerySent(ctx, &SentQuery{Retry: true})
+		assert.NoError(t, err)
+		assert.Len(t, sent, 0)
+		sent, err = m.store.QuerySent(ctx, &SentQuery{Source: source})
+		assert.NoError(t, err)
+		assert.Equal(t, s.ts.URL+"/failing", sent[0].Target)
+		assert.Equal(t, 2, sent[0].Attempts)
+		assert.Equal(t, "", sent[0].LastError)
+
+		// Unless forced.
+		report, err = m.Send(ctx, source, true, s.ts.Client())
+		assert.NoError(t, err)
+		assert.False(t, report.Skipped)
+		assert.Equal(t, 2, s.count("/header"))
+
+		// Or the post is updated.
+		s.mutex.Lock()
+		s.updated = "2019-05-02T00:00:00Z"
+		s.mutex.Unlock()
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.False(t, report.Skipped)
+		assert.Equal(t, 3, s.count("/header"))
+	})
+}
+
+func TestRetrySent_GivesUp(t *testing.T) {
+	s := newSite(t)
+	defer s.ts.Close()
+	s.failing = true
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = 0
+	m.SendMaxAttempts = 2
+	ctx := context.Background()
+
+	_, err := m.Send(ctx, s.ts.URL+"/post", false, s.ts.Client())
+	assert.NoError(t, err)
+	assert.NoError(t, m.RetrySent(ctx, s.ts.Client()))
+	sent, err := m.store.QuerySent(ctx, &SentQuery{Retry: true})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 0)
+	sent, err = m.store.QuerySent(ctx, &SentQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, s.ts.URL+"/failing", sent[0].Target)
+	assert.Equal(t, 2, sent[0].Attempts)
+	assert.NotEqual(t, "", sent[0].LastError)
+}
+
+func TestSend_UpdatesAndDeletes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		s := newSite(t)
+		defer s.ts.Close()
+		ctx := context.Background()
+		source := s.ts.URL + "/post"
+
+		_, err := m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, s.count("/html"))
+
+		// Targets whose links are removed are still sent webmentions, so they
+		// can find out.
+		s.mutex.Lock()
+		s.updated = "2019-05-02T00:00:00Z"
+		s.removed = true
+		s.mutex.Unlock()
+		report, err := m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.Len(t, report.Results, 4)
+		assert.Equal(t, s.ts.URL+"/html", report.Results[3].Target)
+		assert.Equal(t, 2, s.count("/html"))
+		assert.Equal(t, 2, s.count("/header"))
+
+		// All the notified targets are told when the post is deleted.
+		s.mutex.Lock()
+		s.gone = true
+		s.mutex.Unlock()
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.True(t, report.Deleted)
+		assert.False(t, report.Skipped)
+		assert.Len(t, report.Results, 3)
+		for _, result := range report.Results {
+			assert.True(t, result.Deleted)
+			assert.NotEqual(t, "", result.Endpoint)
+		}
+		assert.Equal(t, 3, s.count("/html"))
+		assert.Equal(t, 3, s.count("/header"))
+		assert.Equal(t, 3, s.count("/failing"))
+
+		// But only once.
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.True(t, report.Deleted)
+		assert.True(t, report.Skipped)
+		assert.Equal(t, 3, s.count("/header"))
+
+		// Unless forced.
+		report, err = m.Send(ctx, source, true, s.ts.Client())
+		assert.NoError(t, err)
+		assert.Len(t, report.Results, 3)
+		assert.Equal(t, 4, s.count("/header"))
+
+		// If the post comes back it's sent as usual.
+		s.mutex.Lock()
+		s.gone = false
+		s.mutex.Unlock()
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.False(t, report.Deleted)
+		assert.False(t, report.Skipped)
+		assert.Equal(t, 5, s.count("/header"))
+		for _, result := range report.Results {
+			assert.False(t, result.Deleted)
+		}
+	})
+}
+
+func TestSend_NotifiedAfterDiscoveryFails(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		s := newSite(t)
+		defer s.ts.Close()
+		ctx := context.Background()
+		source := s.ts.URL + "/post"
+		target := s.ts.URL + "/header"
+
+		_, err := m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, s.count("/header"))
+
+		// The endpoint can't be found after the link is removed.
+		s.mutex.Lock()
+		s.updated = "2019-05-02T00:00:00Z"
+		s.broken = true
+		s.mutex.Unlock()
+		report, err := m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		for _, result := range report.Results {
+			if result.Target == target {
+				assert.Equal(t, "", result.Endpoint)
+				assert.True(t, result.Notified)
+			}
+		}
+
+		// But the target is still told when the post is deleted.
+		s.mutex.Lock()
+		s.gone = true
+		s.broken = false
+		s.mutex.Unlock()
+		report, err = m.Send(ctx, source, false, s.ts.Client())
+		assert.NoError(t, err)
+		assert.True(t, report.Deleted)
+		assert.False(t, report.Skipped)
+		assert.Equal(t, 2, s.count("/header"))
+	})
+}
+
+func TestSend_NoHEntry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<p><a href="https://example.com/">link</a></p>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	_, err := m.Send(context.Background(), ts.URL+"/post", false, ts.Client())
+	assert.Error(t, err)
+}
+
+func TestSendFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		s := newSite(t)
+		defer s.ts.Close()
+		ctx := context.Background()
+
+		reports, err := m.SendFeed(ctx, s.ts.URL+"/feed.json", s.ts.Client())
+		assert.NoError(t, err)
+		assert.Len(t, reports, 3)
+		assert.Equal(t, s.ts.URL+"/post", reports[0].Source)
+		assert.Len(t, reports[0].Results, 4)
+		assert.Equal(t, s.ts.URL+"/second", reports[1].Source)
+		assert.Len(t, reports[1].Results, 1)
+		assert.Equal(t, s.ts.URL+"/third", reports[2].Source)
+		assert.Len(t, reports[2].Results, 0)
+		assert.Equal(t, 1, s.count("/header"))
+		assert.Equal(t, 2, s.count("/html"))
+
+		// Only deliveries are listed, not when each entry was sent.
+		sent, err := m.SentDeliveries(ctx, 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, sent, 5)
+
+		// Nothing has changed, including the entry without links.
+		reports, err = m.SendFeed(ctx, s.ts.URL+"/feed.json", s.ts.Client())
+		assert.NoError(t, err)
+		assert.Len(t, reports, 0)
+
+		// Only the updated entry is sent.
+		s.mutex.Lock()
+		s.updated = "2019-05-02T00:00:00Z"
+		s.mutex.Unlock()
+		reports, err = m.SendFeed(ctx, s.ts.URL+"/feed.json", s.ts.Client())
+		assert.NoError(t, err)
+		assert.Len(t, reports, 1)
+		assert.Equal(t, 2, s.count("/header"))
+		assert.Equal(t, 3, s.count("/html"))
+	})
+}
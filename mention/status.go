@@ -0,0 +1,115 @@
+package mention
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Statuses reported to the senders of mentions.
+const (
+	// QUEUED_STATUS is for mentions waiting to be verified, or re-verified.
+	QUEUED_STATUS = "queued"
+
+	// VERIFIED_STATUS is for mentions that have been accepted.
+	VERIFIED_STATUS = "verified"
+
+	// REJECTED_STATUS is for mentions that failed verification or were marked
+	// as spam.
+	REJECTED_STATUS = "rejected"
+
+	// DELETED_STATUS is for mentions whose source was deleted or no longer
+	// links to the target.
+	DELETED_STATUS = "deleted"
+)
+
+// Reasons reported to the senders of mentions that failed verification. The
+// full error is only shown on the triage page.
+const (
+	// BLOCKED_REASON is for sources that are refused, such as private
+	// addresses.
+	BLOCKED_REASON = "blocked"
+
+	// UNREACHABLE_REASON is for sources that couldn't be retrieved.
+	UNREACHABLE_REASON = "unreachable"
+
+	// CONTENT_TYPE_REASON is for sources that aren't HTML.
+	CONTENT_TYPE_REASON = "unsupported content type"
+
+	// TOO_LARGE_REASON is for sources larger than MaxSourceBytes.
+	TOO_LARGE_REASON = "too large"
+
+	// NO_LINK_REASON is for sources that don't link to the target.
+	NO_LINK_REASON = "no link"
+
+	// GONE_REASON is for sources that have been deleted.
+	GONE_REASON = "gone"
+)
+
+// reasonOf returns the reason reported to the sender for the verification
+// error err, empty if err is nil.
+func reasonOf(err error) string {
+	var f failure
+	switch {
+	case err == nil:
+		return ""
+	case err == ErrNoLink:
+		return NO_LINK_REASON
+	case err == ErrSourceGone:
+		return GONE_REASON
+	case errors.As(err, &f):
+		return f.reason
+	}
+	return UNREACHABLE_REASON
+}
+
+// Status is the processing status of a single mention.
+type Status struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Status string `json:"status"`
+
+	// Reason is why verification failed, or why the last attempt failed for
+	// a mention that will be retried, as one of the *_REASON constants.
+	Reason string `json:"reason,omitempty"`
+
+	// NextAttempt is when a queued mention will next be verified, if it is
+	// waiting to be retried.
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// statusOf returns the Status of the mention.
+func statusOf(mention *Mention) *Status {
+	ret := &Status{
+		Source: mention.Source,
+		Target: mention.Target,
+	}
+	switch {
+	case mention.State == UNTRIAGED_STATE || mention.Reverify:
+		ret.Status = QUEUED_STATUS
+		ret.Reason = mention.Reason
+		if !mention.NextAttempt.IsZero() {
+			next := mention.NextAttempt
+			ret.NextAttempt = &next
+		}
+	case mention.State == GOOD_STATE:
+		ret.Status = VERIFIED_STATUS
+	case mention.State == DELETED_STATE:
+		ret.Status = DELETED_STATUS
+		ret.Reason = mention.Reason
+	default:
+		ret.Status = REJECTED_STATUS
+		ret.Reason = mention.Reason
+	}
+	return ret
+}
+
+// GetStatus returns the Status of the mention with the given key, or
+// ErrNotFound if there is no such mention.
+func (m *Mentions) GetStatus(ctx context.Context, key string) (*Status, error) {
+	mention, err := m.store.GetMention(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return statusOf(mention), nil
+}
@@ -0,0 +1,127 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOf(t *testing.T) {
+	next := time.Now().Add(time.Hour)
+	tests := []struct {
+		mention Mention
+		status  string
+		reason  string
+	}{
+		{Mention{State: UNTRIAGED_STATE}, QUEUED_STATUS, ""},
+		{Mention{State: UNTRIAGED_STATE, LastError: "503", Reason: UNREACHABLE_REASON, NextAttempt: next}, QUEUED_STATUS, UNREACHABLE_REASON},
+		{Mention{State: GOOD_STATE, Reverify: true}, QUEUED_STATUS, ""},
+		{Mention{State: GOOD_STATE, PhotoError: "Failed to use author photo"}, VERIFIED_STATUS, ""},
+		{Mention{State: SPAM_STATE, LastError: ErrNoLink.Error(), Reason: NO_LINK_REASON}, REJECTED_STATUS, NO_LINK_REASON},
+		{Mention{State: DELETED_STATE, LastError: ErrSourceGone.Error(), Reason: GONE_REASON}, DELETED_STATUS, GONE_REASON},
+		// The error itself is never reported.
+		{Mention{State: SPAM_STATE, LastError: "Failed to retrieve source: dial tcp 10.0.0.1:80"}, REJECTED_STATUS, ""},
+	}
+	for _, tc := range tests {
+		status := statusOf(&tc.mention)
+		assert.Equal(t, tc.status, status.Status, "%#v", tc.mention)
+		assert.Equal(t, tc.reason, status.Reason, "%#v", tc.mention)
+	}
+	status := statusOf(&tests[1].mention)
+	assert.True(t, next.Equal(*status.NextAttempt))
+}
+
+func TestReasonOf(t *testing.T) {
+	assert.Equal(t, "", reasonOf(nil))
+	assert.Equal(t, NO_LINK_REASON, reasonOf(ErrNoLink))
+	assert.Equal(t, GONE_REASON, reasonOf(ErrSourceGone))
+	assert.Equal(t, BLOCKED_REASON, reasonOf(failure{BLOCKED_REASON, fmt.Errorf("Refused")}))
+	assert.Equal(t, TOO_LARGE_REASON, reasonOf(transientError{failure{TOO_LARGE_REASON, fmt.Errorf("Large")}}))
+	assert.Equal(t, UNREACHABLE_REASON, reasonOf(fmt.Errorf("Something else")))
+}
+
+func TestGetStatus(t *testing.T) {
+	forEachStore(t, func(t *testing.T, m *Mentions) {
+		ctx := context.Background()
+		_, err := m.GetStatus(ctx, New("https://example.com/nope", "https://bitworking.org/bar").Key())
+		assert.Equal(t, ErrNotFound, err)
+
+		mention := New("https://example.com/reply", "https://bitworking.org/bar")
+		assert.NoError(t, m.Put(ctx, mention))
+		status, err := m.GetStatus(ctx, mention.Key())
+		assert.NoError(t, err)
+		assert.Equal(t, QUEUED_STATUS, status.Status)
+		assert.Equal(t, "https://example.com/reply", status.Source)
+		assert.Equal(t, "https://bitworking.org/bar", status.Target)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	hasLink := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if hasLink {
+			_, err := w.Write([]byte(`<a href="https://bitworking.org/bar">link</a>`))
+			assert.NoError(t, err)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+
+	good := New(ts.URL+"/good", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, good))
+	assert.NoError(t, m.Verify(ctx, good.Key(), ts.Client()))
+	status, err := m.GetStatus(ctx, good.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFIED_STATUS, status.Status)
+
+	hasLink = false
+	bad := New(ts.URL+"/bad", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, bad))
+	assert.NoError(t, m.Verify(ctx, bad.Key(), ts.Client()))
+	status, err = m.GetStatus(ctx, bad.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, REJECTED_STATUS, status.Status)
+	assert.Equal(t, NO_LINK_REASON, status.Reason)
+
+	// Only the reason is reported, the error is kept for the triage page.
+	notHTML := New(ts.URL+"/not-html", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, notHTML))
+	m.SourceContentTypes = []string{"application/xhtml+xml"}
+	assert.NoError(t, m.Verify(ctx, notHTML.Key(), ts.Client()))
+	status, err = m.GetStatus(ctx, notHTML.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, REJECTED_STATUS, status.Status)
+	assert.Equal(t, CONTENT_TYPE_REASON, status.Reason)
+	stored, err := m.store.GetMention(ctx, notHTML.Key())
+	assert.NoError(t, err)
+	assert.Contains(t, stored.LastError, "text/html")
+}
+
+func TestVerify_DeadlineLeavesMentionQueued(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	mention := New(ts.URL+"/slow", "https://bitworking.org/bar")
+	assert.NoError(t, m.Put(ctx, mention))
+
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m.Verify(tctx, mention.Key(), ts.Client()))
+	status, err := m.GetStatus(ctx, mention.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, QUEUED_STATUS, status.Status)
+	assert.Equal(t, 0, len(status.Reason))
+}
@@ -0,0 +1,172 @@
+package mention
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// ErrNotFound is returned from a Store when the requested entity doesn't exist.
+var ErrNotFound = errors.New("Not found.")
+
+// Query describes a set of Mentions to retrieve from a Store.
+//
+// Empty fields are not used as filters. Results are ordered by TS, oldest
+// first, unless NewestFirst is true.
+type Query struct {
+	Target      string
+	State       string
+	NewestFirst bool
+
+	// Reverify, if true, only returns Mentions queued for re-verification.
+	Reverify bool
+
+	// Type, if set, only returns Mentions of that type, e.g. LIKE_TYPE.
+	Type string
+
+	// Limit is the maximum number of results to return, 0 means no limit.
+	Limit  int
+	Offset int
+}
+
+// matches returns true if the mention passes all the filters in the query.
+func (q *Query) matches(mention *Mention) bool {
+	if q.Target != "" && mention.Target != q.Target {
+		return false
+	}
+	if q.State != "" && mention.State != q.State {
+		return false
+	}
+	if q.Reverify && !mention.Reverify {
+		return false
+	}
+	if q.Type != "" && mention.Type != q.Type {
+		return false
+	}
+	return true
+}
+
+// sortAndPage orders the results as requested by the query and then applies
+// the Offset and Limit.
+//
+// Stores that can't do the ordering and paging themselves can filter with
+// matches() and then call sortAndPage().
+func (q *Query) sortAndPage(mentions []*MentionWithKey) []*MentionWithKey {
+	sort.SliceStable(mentions, func(i, j int) bool {
+		if mentions[i].TS.Equal(mentions[j].TS) {
+			return mentions[i].Key < mentions[j].Key
+		}
+		if q.NewestFirst {
+			return mentions[i].TS.After(mentions[j].TS)
+		}
+		return mentions[i].TS.Before(mentions[j].TS)
+	})
+	if q.Offset > 0 {
+		if q.Offset >= len(mentions) {
+			return []*MentionWithKey{}
+		}
+		mentions = mentions[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(mentions) {
+		mentions = mentions[:q.Limit]
+	}
+	return mentions
+}
+
+// SentQuery describes a set of WebMentionSent records to retrieve from a
+// Store.
+//
+// Empty fields are not used as filters. Results are ordered by TS, newest
+// first.
+type SentQuery struct {
+	Source string
+
+	// Retry, if true, only returns failed deliveries that will be retried.
+	Retry bool
+
+	// Limit is the maximum number of results to return, 0 means no limit.
+	Limit  int
+	Offset int
+}
+
+// matches returns true if the record passes all the filters in the query.
+func (q *SentQuery) matches(sent *WebMentionSent) bool {
+	// Records from before deliveries were tracked per target have no Target.
+	if sent.Target == "" {
+		return false
+	}
+	if q.Source != "" && sent.Source != q.Source {
+		return false
+	}
+	if q.Retry && sent.NextAttempt.IsZero() {
+		return false
+	}
+	return true
+}
+
+// sortAndPage orders the results and then applies the Offset and Limit.
+func (q *SentQuery) sortAndPage(sent []*WebMentionSent) []*WebMentionSent {
+	sort.SliceStable(sent, func(i, j int) bool {
+		if sent[i].TS.Equal(sent[j].TS) {
+			return sent[i].Key() < sent[j].Key()
+		}
+		return sent[i].TS.After(sent[j].TS)
+	})
+	if q.Offset > 0 {
+		if q.Offset >= len(sent) {
+			return []*WebMentionSent{}
+		}
+		sent = sent[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(sent) {
+		sent = sent[:q.Limit]
+	}
+	return sent
+}
+
+// Store is the persistence layer used by Mentions.
+//
+// Mentions are stored under the key returned from Mention.Key(), thumbnails
+// under the hash of their contents, sent records under the key returned from
+// WebMentionSent.Key(), and sent sources under the key returned from
+// SentSource.Key().
+type Store interface {
+	// GetMention returns the Mention stored under key, or ErrNotFound.
+	GetMention(ctx context.Context, key string) (*Mention, error)
+
+	// PutMention writes the Mention under key, replacing any existing value.
+	PutMention(ctx context.Context, key string, mention *Mention) error
+
+	// UpdateMention reads the Mention stored under key, passes it to f, and
+	// writes the modified Mention back, all in a single transaction. Returns
+	// ErrNotFound if there is no Mention under key. If f returns an error
+	// nothing is written.
+	UpdateMention(ctx context.Context, key string, f func(*Mention) error) error
+
+	// DeleteMention removes the Mention stored under key. Deleting a Mention
+	// that doesn't exist isn't an error.
+	DeleteMention(ctx context.Context, key string) error
+
+	// QueryMentions returns all the Mentions that match the query.
+	QueryMentions(ctx context.Context, q *Query) ([]*MentionWithKey, error)
+
+	// GetThumbnail returns the Thumbnail stored under id, or ErrNotFound.
+	GetThumbnail(ctx context.Context, id string) (*Thumbnail, error)
+
+	// PutThumbnail writes the Thumbnail under id.
+	PutThumbnail(ctx context.Context, id string, t *Thumbnail) error
+
+	// PutSent writes the WebMentionSent record under key, replacing any
+	// existing value.
+	PutSent(ctx context.Context, key string, sent *WebMentionSent) error
+
+	// QuerySent returns all the WebMentionSent records that match the query.
+	QuerySent(ctx context.Context, q *SentQuery) ([]*WebMentionSent, error)
+
+	// GetSentSource returns the SentSource stored under key, or ErrNotFound.
+	GetSentSource(ctx context.Context, key string) (*SentSource, error)
+
+	// PutSentSource writes the SentSource under key, replacing any existing
+	// value.
+	PutSentSource(ctx context.Context, key string, s *SentSource) error
+}
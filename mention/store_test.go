@@ -0,0 +1,198 @@
+package mention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore exercises all the queries that Mentions makes against a Store.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	_, err := s.GetMention(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	mentions := []*Mention{
+		{Source: "https://a.example.com/", Target: "https://bitworking.org/bar", State: GOOD_STATE, TS: now.Add(-3 * time.Minute), Type: LIKE_TYPE},
+		{Source: "https://b.example.com/", Target: "https://bitworking.org/bar", State: SPAM_STATE, TS: now.Add(-2 * time.Minute), Type: LIKE_TYPE},
+		{Source: "https://c.example.com/", Target: "https://bitworking.org/bar", State: GOOD_STATE, TS: now.Add(-1 * time.Minute), Type: REPLY_TYPE, ContentHTML: "<p>Agreed.</p>", ContentText: "Agreed."},
+		{Source: "https://d.example.com/", Target: "https://bitworking.org/baz", State: UNTRIAGED_STATE, TS: now},
+		{Source: "https://e.example.com/", Target: "https://bitworking.org/baz", State: SPAM_STATE, TS: now.Add(-4 * time.Minute), Updated: now, Reverify: true},
+	}
+	for _, mention := range mentions {
+		assert.NoError(t, s.PutMention(ctx, mention.Key(), mention))
+	}
+
+	got, err := s.GetMention(ctx, mentions[0].Key())
+	assert.NoError(t, err)
+	assert.Equal(t, mentions[0].Source, got.Source)
+	assert.True(t, mentions[0].TS.Equal(got.TS))
+	assert.True(t, got.Updated.IsZero())
+
+	got, err = s.GetMention(ctx, mentions[2].Key())
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>Agreed.</p>", got.ContentHTML)
+	assert.Equal(t, "Agreed.", got.ContentText)
+
+	// By Target and State, oldest first.
+	res, err := s.QueryMentions(ctx, &Query{Target: "https://bitworking.org/bar", State: GOOD_STATE})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, "https://a.example.com/", res[0].Source)
+	assert.Equal(t, "https://c.example.com/", res[1].Source)
+	assert.Equal(t, mentions[0].Key(), res[0].Key)
+
+	// By Target only.
+	res, err = s.QueryMentions(ctx, &Query{Target: "https://bitworking.org/bar"})
+	assert.NoError(t, err)
+	assert.Len(t, res, 3)
+
+	// By Target, State, and Type.
+	res, err = s.QueryMentions(ctx, &Query{Target: "https://bitworking.org/bar", State: GOOD_STATE, Type: LIKE_TYPE})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "https://a.example.com/", res[0].Source)
+	assert.Equal(t, LIKE_TYPE, res[0].Type)
+
+	// By Type only.
+	res, err = s.QueryMentions(ctx, &Query{Type: LIKE_TYPE})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+
+	// Untriaged.
+	res, err = s.QueryMentions(ctx, &Query{State: UNTRIAGED_STATE})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "https://d.example.com/", res[0].Source)
+
+	// Queued for re-verification.
+	res, err = s.QueryMentions(ctx, &Query{Reverify: true})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "https://e.example.com/", res[0].Source)
+	assert.True(t, now.Equal(res[0].Updated))
+
+	// Newest first with limit and offset, as in GetTriage.
+	res, err = s.QueryMentions(ctx, &Query{NewestFirst: true, Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, "https://c.example.com/", res[0].Source)
+	assert.Equal(t, "https://b.example.com/", res[1].Source)
+
+	res, err = s.QueryMentions(ctx, &Query{NewestFirst: true, Limit: 2, Offset: 10})
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	// Transactional update.
+	err = s.UpdateMention(ctx, mentions[1].Key(), func(mention *Mention) error {
+		mention.State = GOOD_STATE
+		return nil
+	})
+	assert.NoError(t, err)
+	got, err = s.GetMention(ctx, mentions[1].Key())
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, got.State)
+
+	err = s.UpdateMention(ctx, "missing", func(mention *Mention) error {
+		return nil
+	})
+	assert.Equal(t, ErrNotFound, err)
+
+	// A failed update doesn't write anything.
+	err = s.UpdateMention(ctx, mentions[1].Key(), func(mention *Mention) error {
+		mention.State = SPAM_STATE
+		return ErrNotFound
+	})
+	assert.Error(t, err)
+	got, err = s.GetMention(ctx, mentions[1].Key())
+	assert.NoError(t, err)
+	assert.Equal(t, GOOD_STATE, got.State)
+
+	// Deletes.
+	assert.NoError(t, s.DeleteMention(ctx, mentions[2].Key()))
+	_, err = s.GetMention(ctx, mentions[2].Key())
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, s.DeleteMention(ctx, mentions[2].Key()))
+	res, err = s.QueryMentions(ctx, &Query{Target: "https://bitworking.org/bar"})
+	assert.NoError(t, err)
+	assert.Len(t, res, 2)
+
+	// Thumbnails.
+	_, err = s.GetThumbnail(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, s.PutThumbnail(ctx, "abc", &Thumbnail{PNG: []byte("png")}))
+	thumb, err := s.GetThumbnail(ctx, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), thumb.PNG)
+
+	// Sent records.
+	sent, err := s.QuerySent(ctx, &SentQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 0)
+	for _, sent := range []*WebMentionSent{
+		{Source: "https://bitworking.org/bar", Target: "https://example.com/1", TS: now.Add(-time.Minute), Updated: now, Endpoint: "https://example.com/wm", Status: 202, Location: "https://example.com/wm/1", Attempts: 1},
+		{Source: "https://bitworking.org/bar", Target: "https://example.com/2", TS: now, Updated: now, Attempts: 2, LastError: "Failed", NextAttempt: now.Add(time.Hour)},
+		{Source: "https://bitworking.org/baz", Target: "https://example.com/1", TS: now.Add(-time.Hour), Updated: now, Deleted: true},
+	} {
+		assert.NoError(t, s.PutSent(ctx, sent.Key(), sent))
+	}
+	sent, err = s.QuerySent(ctx, &SentQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 3)
+	assert.Equal(t, "https://example.com/2", sent[0].Target)
+	assert.Equal(t, "https://example.com/1", sent[1].Target)
+	assert.Equal(t, "https://bitworking.org/baz", sent[2].Source)
+	assert.True(t, sent[2].Deleted)
+	assert.False(t, sent[1].Deleted)
+	assert.Equal(t, "https://example.com/wm", sent[1].Endpoint)
+	assert.Equal(t, 202, sent[1].Status)
+	assert.Equal(t, "https://example.com/wm/1", sent[1].Location)
+	assert.True(t, now.Equal(sent[1].Updated))
+
+	sent, err = s.QuerySent(ctx, &SentQuery{Source: "https://bitworking.org/bar"})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 2)
+
+	sent, err = s.QuerySent(ctx, &SentQuery{Retry: true})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 1)
+	assert.Equal(t, 2, sent[0].Attempts)
+	assert.Equal(t, "Failed", sent[0].LastError)
+	assert.True(t, now.Add(time.Hour).Equal(sent[0].NextAttempt))
+
+	sent, err = s.QuerySent(ctx, &SentQuery{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 1)
+	assert.Equal(t, "https://example.com/1", sent[0].Target)
+
+	// Writing to the same source and target replaces the record.
+	retried := &WebMentionSent{Source: "https://bitworking.org/bar", Target: "https://example.com/2", TS: now, Updated: now, Attempts: 3, Status: 200}
+	assert.NoError(t, s.PutSent(ctx, retried.Key(), retried))
+	sent, err = s.QuerySent(ctx, &SentQuery{Retry: true})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 0)
+
+	// Sent sources, which aren't sent records.
+	source := &SentSource{Source: "https://bitworking.org/bar", TS: now, Updated: now.Add(-time.Hour)}
+	_, err = s.GetSentSource(ctx, source.Key())
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, s.PutSentSource(ctx, source.Key(), source))
+	last, err := s.GetSentSource(ctx, source.Key())
+	assert.NoError(t, err)
+	assert.Equal(t, source.Source, last.Source)
+	assert.True(t, now.Equal(last.TS))
+	assert.True(t, now.Add(-time.Hour).Equal(last.Updated))
+	assert.False(t, last.Deleted)
+	source.Deleted = true
+	assert.NoError(t, s.PutSentSource(ctx, source.Key(), source))
+	last, err = s.GetSentSource(ctx, source.Key())
+	assert.NoError(t, err)
+	assert.True(t, last.Deleted)
+	sent, err = s.QuerySent(ctx, &SentQuery{Source: "https://bitworking.org/bar"})
+	assert.NoError(t, err)
+	assert.Len(t, sent, 2)
+}
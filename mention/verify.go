@@ -0,0 +1,170 @@
+package mention
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transientError wraps verification errors that may go away if verification
+// is retried later, such as DNS failures, timeouts, and 503s.
+type transientError struct {
+	error
+}
+
+func (t transientError) Unwrap() error {
+	return t.error
+}
+
+// failure is a verification error along with the short reason for it that
+// is reported to the sender, see reasonOf.
+type failure struct {
+	reason string
+	err    error
+}
+
+func (f failure) Error() string {
+	return f.err.Error()
+}
+
+func (f failure) Unwrap() error {
+	return f.err
+}
+
+// isTransient returns true if err is a transientError.
+func isTransient(err error) bool {
+	_, ok := err.(transientError)
+	return ok
+}
+
+// isTransientStatus returns true for HTTP status codes that indicate the
+// source may be available if requested again later.
+func isTransientStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// backoff returns how long to wait before the next verification attempt,
+// given the number of attempts made so far.
+func (m *Mentions) backoff(attempts int) time.Duration {
+	delay := m.VerifyBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if m.VerifyMaxBackoff > 0 && delay >= m.VerifyMaxBackoff {
+			return m.VerifyMaxBackoff
+		}
+	}
+	return delay
+}
+
+// hostLimiter limits the number of concurrent requests made to each host.
+type hostLimiter struct {
+	mutex   sync.Mutex
+	perHost int
+	hosts   map[string]chan struct{}
+}
+
+func newHostLimiter(perHost int) *hostLimiter {
+	if perHost < 1 {
+		perHost = 1
+	}
+	return &hostLimiter{
+		perHost: perHost,
+		hosts:   map[string]chan struct{}{},
+	}
+}
+
+// acquire blocks until a request can be made to host, or ctx expires. On
+// success the returned func must be called once the request is done.
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	h.mutex.Lock()
+	sem, ok := h.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, h.perHost)
+		h.hosts[host] = sem
+	}
+	h.mutex.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// hostOf returns the lowercased hostname of the URL u, or "" if u doesn't
+// parse.
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// VerifyQueuedMentions verifies all the queued mentions, VerifyParallelism at
+// a time, fetching at most VerifyPerHost sources from any one host at a time.
+//
+// Once ctx expires no more mentions are started, and any mentions that were
+// in flight are left unchanged, so they stay queued for the next run.
+func (m *Mentions) VerifyQueuedMentions(ctx context.Context, c *http.Client) {
+	queued := m.GetQueued(ctx)
+	m.log.Infof("About to slow verify %d queud mentions.", len(queued))
+
+	parallelism := m.VerifyParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	hosts := newHostLimiter(m.VerifyPerHost)
+	work := make(chan *Mention)
+	var started int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mention := range work {
+				release, err := hosts.acquire(ctx, hostOf(mention.Source))
+				if err != nil {
+					continue
+				}
+				if ctx.Err() == nil {
+					atomic.AddInt64(&started, 1)
+					m.verify(ctx, mention, c)
+				}
+				release()
+			}
+		}()
+	}
+
+loop:
+	for _, mention := range queued {
+		select {
+		case work <- mention:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		m.log.Warningf("Stopped verifying after starting %d of %d queued mentions: %s", atomic.LoadInt64(&started), len(queued), err)
+	}
+}
+
+// Verify immediately verifies the mention with the given key, returning
+// ctx.Err() if ctx expires first, in which case the mention stays queued.
+func (m *Mentions) Verify(ctx context.Context, key string, c *http.Client) error {
+	mention, err := m.store.GetMention(ctx, key)
+	if err != nil {
+		return err
+	}
+	m.verify(ctx, mention, c)
+	return ctx.Err()
+}
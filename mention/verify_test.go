@@ -0,0 +1,197 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/webmention-run/safehttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyQueuedMentions_PerHostLimit(t *testing.T) {
+	var mutex sync.Mutex
+	active := 0
+	maxActive := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte(`<a href="https://bitworking.org/bar">link</a>`))
+		assert.NoError(t, err)
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyParallelism = 5
+	m.VerifyPerHost = 2
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.Put(ctx, New(fmt.Sprintf("%s/%d", ts.URL, i), "https://bitworking.org/bar")))
+	}
+	m.VerifyQueuedMentions(ctx, ts.Client())
+
+	assert.Len(t, m.GetGood(ctx, "https://bitworking.org/bar"), 10)
+	assert.Len(t, m.GetQueued(ctx), 0)
+	assert.Equal(t, 2, maxActive)
+}
+
+func TestVerifyQueuedMentions_DeadlineLeavesMentionsQueued(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.Put(context.Background(), New(fmt.Sprintf("%s/%d", ts.URL, i), "https://bitworking.org/bar")))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m.VerifyQueuedMentions(ctx, ts.Client())
+
+	queued := m.GetQueued(context.Background())
+	assert.Len(t, queued, 10)
+	for _, mention := range queued {
+		assert.Equal(t, UNTRIAGED_STATE, mention.State)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = time.Minute
+	m.VerifyMaxBackoff = 10 * time.Minute
+	assert.Equal(t, time.Minute, m.backoff(1))
+	assert.Equal(t, 2*time.Minute, m.backoff(2))
+	assert.Equal(t, 8*time.Minute, m.backoff(4))
+	assert.Equal(t, 10*time.Minute, m.backoff(5))
+	assert.Equal(t, 10*time.Minute, m.backoff(50))
+}
+
+func TestVerifyQueuedMentions_RetriesTransientFailures(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(`<a href="https://bitworking.org/bar">link</a>`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = 0
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+
+	// A 503 leaves the mention queued with the error recorded.
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	queued := m.GetQueued(ctx)
+	assert.Len(t, queued, 1)
+	assert.Equal(t, UNTRIAGED_STATE, queued[0].State)
+	assert.Equal(t, 1, queued[0].Attempts)
+	assert.Equal(t, "Failed to retrieve source: 503", queued[0].LastError)
+
+	// Then the site comes back.
+	status = http.StatusOK
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	good := m.GetGood(ctx, "https://bitworking.org/bar")
+	assert.Len(t, good, 1)
+	assert.Equal(t, 2, good[0].Attempts)
+	assert.Equal(t, "", good[0].LastError)
+	assert.Len(t, m.GetQueued(ctx), 0)
+}
+
+func TestVerifyQueuedMentions_WaitsForBackoff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = time.Hour
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	assert.Len(t, m.GetQueued(ctx), 0)
+
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Len(t, all, 1)
+	assert.Equal(t, UNTRIAGED_STATE, all[0].State)
+	assert.True(t, all[0].NextAttempt.After(time.Now().Add(59*time.Minute)))
+
+	// Re-sending the mention retries it right away.
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	assert.Len(t, m.GetQueued(ctx), 1)
+}
+
+func TestVerifyQueuedMentions_GivesUpAfterMaxAttempts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = 0
+	m.VerifyMaxAttempts = 3
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	for i := 0; i < 3; i++ {
+		m.VerifyQueuedMentions(ctx, ts.Client())
+	}
+	assert.Len(t, m.GetQueued(ctx), 0)
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Len(t, all, 1)
+	assert.Equal(t, SPAM_STATE, all[0].State)
+	assert.Equal(t, 3, all[0].Attempts)
+	assert.Equal(t, "Failed to retrieve source: 503", all[0].LastError)
+}
+
+func TestVerifyQueuedMentions_PermanentFailuresAreNotRetried(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = 0
+	assert.NoError(t, m.Put(ctx, New(ts.URL+"/reply", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, ts.Client())
+	assert.Len(t, m.GetQueued(ctx), 0)
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Equal(t, SPAM_STATE, all[0].State)
+	assert.Equal(t, 1, all[0].Attempts)
+}
+
+func TestVerifyQueuedMentions_RefusedFetchesAreNotRetried(t *testing.T) {
+	ctx := context.Background()
+	m := NewMentionsFromStore(NewMemoryStore(), logger.New())
+	m.VerifyBackoff = 0
+	assert.NoError(t, m.Put(ctx, New("http://169.254.169.254/computeMetadata/v1/", "https://bitworking.org/bar")))
+	m.VerifyQueuedMentions(ctx, safehttp.NewClient(safehttp.Options{}))
+	assert.Len(t, m.GetQueued(ctx), 0)
+	all := m.GetAll(ctx, "https://bitworking.org/bar")
+	assert.Equal(t, SPAM_STATE, all[0].State)
+	assert.Equal(t, 1, all[0].Attempts)
+	assert.Contains(t, all[0].LastError, "Request refused")
+}
@@ -0,0 +1,220 @@
+// safehttp provides an http.Client that is safe to use for fetching URLs
+// supplied by anonymous users, such as webmention sources and author photos.
+//
+// The client refuses to connect to private, loopback, link-local, and other
+// special purpose addresses, checked after DNS resolution and again on every
+// redirect, so it can't be used to reach internal services like the cloud
+// metadata server at http://169.254.169.254. Only the http and https schemes
+// are allowed, on their standard ports or on explicitly allowed ports.
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jcgregorio/slog"
+)
+
+// blockedNets are the address ranges that may not be connected to.
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",       // "This" network.
+	"10.0.0.0/8",      // RFC1918 private.
+	"100.64.0.0/10",   // Carrier-grade NAT.
+	"127.0.0.0/8",     // Loopback.
+	"169.254.0.0/16",  // Link-local, including cloud metadata servers.
+	"172.16.0.0/12",   // RFC1918 private.
+	"192.0.0.0/24",    // IETF protocol assignments.
+	"192.168.0.0/16",  // RFC1918 private.
+	"198.18.0.0/15",   // Benchmarking.
+	"224.0.0.0/4",     // Multicast.
+	"240.0.0.0/4",     // Reserved, including broadcast.
+	"::/128",          // Unspecified.
+	"::1/128",         // Loopback.
+	"64:ff9b::/96",    // NAT64, which can map to any IPv4 address.
+	"fc00::/7",        // Unique local.
+	"fe80::/10",       // Link-local.
+	"ff00::/8",        // Multicast.
+	"2001:db8::/32",   // Documentation.
+	"2002::/16",       // 6to4, which can map to any IPv4 address.
+	"100::/64",        // Discard.
+	"2001::/23",       // IETF protocol assignments.
+	"fec0::/10",       // Deprecated site-local.
+	"192.88.99.0/24",  // 6to4 relay anycast.
+	"203.0.113.0/24",  // Documentation.
+	"198.51.100.0/24", // Documentation.
+	"192.0.2.0/24",    // Documentation.
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	ret := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}
+
+// RejectedError is returned when a request is refused.
+type RejectedError struct {
+	Reason string
+}
+
+func (r *RejectedError) Error() string {
+	return "Request refused: " + r.Reason
+}
+
+// IsRejected returns true if err, or any error it wraps, is a RejectedError.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// CheckIP returns a RejectedError if ip is not a public unicast address.
+func CheckIP(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return &RejectedError{Reason: fmt.Sprintf("%s is in the blocked range %s", ip, n)}
+		}
+	}
+	return nil
+}
+
+// Options for NewClient.
+type Options struct {
+	// Timeout for each request, including redirects. Defaults to 30s.
+	Timeout time.Duration
+
+	// AllowedPorts are ports allowed in addition to 80 and 443.
+	AllowedPorts []int
+
+	// Log, if not nil, is where rejected requests are logged.
+	Log slog.Logger
+
+	// checkIP is CheckIP unless overridden in tests.
+	checkIP func(net.IP) error
+}
+
+// guard enforces the Options on requests and connections.
+type guard struct {
+	ports   map[string]bool
+	log     slog.Logger
+	checkIP func(net.IP) error
+}
+
+func (g *guard) reject(what string, err error) error {
+	if g.log != nil {
+		g.log.Warningf("Refused to fetch %s: %s", what, err)
+	}
+	return err
+}
+
+// checkURL is applied to every request, including each redirect.
+func (g *guard) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return g.reject(u.String(), &RejectedError{Reason: fmt.Sprintf("scheme %q is not allowed", u.Scheme)})
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if !g.ports[port] {
+		return g.reject(u.String(), &RejectedError{Reason: fmt.Sprintf("port %s is not allowed", port)})
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := g.checkIP(ip); err != nil {
+			return g.reject(u.String(), err)
+		}
+	}
+	return nil
+}
+
+// control is called with the resolved address just before each connection
+// is made, which catches hostnames that resolve to blocked addresses.
+func (g *guard) control(network, address string, c syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return g.reject(address, &RejectedError{Reason: fmt.Sprintf("bad address: %s", err)})
+	}
+	if !g.ports[port] {
+		return g.reject(address, &RejectedError{Reason: fmt.Sprintf("port %s is not allowed", port)})
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return g.reject(address, &RejectedError{Reason: "not an IP address"})
+	}
+	if err := g.checkIP(ip); err != nil {
+		return g.reject(address, err)
+	}
+	return nil
+}
+
+// transport checks each request's URL before passing it on.
+type transport struct {
+	guard *guard
+	base  http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.guard.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// NewClient returns a new http.Client hardened against server-side request
+// forgery.
+func NewClient(opts Options) *http.Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	g := &guard{
+		ports: map[string]bool{
+			"80":  true,
+			"443": true,
+		},
+		log:     opts.Log,
+		checkIP: opts.checkIP,
+	}
+	if g.checkIP == nil {
+		g.checkIP = CheckIP
+	}
+	for _, port := range opts.AllowedPorts {
+		g.ports[strconv.Itoa(port)] = true
+	}
+	dialer := &net.Dialer{
+		Timeout:   opts.Timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}
+	base := &http.Transport{
+		// Never use a proxy, the connection checks must see the real address.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &transport{
+			guard: g,
+			base:  base,
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package safehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIP(t *testing.T) {
+	blocked := []string{
+		"127.0.0.1",
+		"10.1.2.3",
+		"172.16.0.1",
+		"192.168.1.1",
+		"169.254.169.254",
+		"100.64.0.1",
+		"0.0.0.0",
+		"255.255.255.255",
+		"224.0.0.1",
+		"::1",
+		"::",
+		"fe80::1",
+		"fd00::1",
+		"::ffff:127.0.0.1",
+		"::ffff:169.254.169.254",
+		"64:ff9b::a9fe:a9fe",
+	}
+	for _, s := range blocked {
+		err := CheckIP(net.ParseIP(s))
+		assert.Error(t, err, s)
+		assert.True(t, IsRejected(err), s)
+	}
+	allowed := []string{
+		"8.8.8.8",
+		"93.184.216.34",
+		"2606:2800:220:1:248:1893:25c8:1946",
+	}
+	for _, s := range allowed {
+		assert.NoError(t, CheckIP(net.ParseIP(s)), s)
+	}
+}
+
+// portOf returns the port of the test server.
+func portOf(t *testing.T, ts *httptest.Server) int {
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return port
+}
+
+func TestNewClient_RefusesLoopback(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+
+	c := NewClient(Options{AllowedPorts: []int{portOf(t, ts)}})
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.True(t, IsRejected(err))
+
+	// Also when the address is only known after resolving a hostname.
+	_, err = c.Get(fmt.Sprintf("http://localhost:%d/", portOf(t, ts)))
+	assert.Error(t, err)
+	assert.True(t, IsRejected(err))
+	assert.False(t, called)
+}
+
+func TestNewClient_SchemesAndPorts(t *testing.T) {
+	c := NewClient(Options{})
+	for _, u := range []string{
+		"ftp://example.com/",
+		"file:///etc/passwd",
+		"gopher://example.com/",
+		"http://example.com:22/",
+		"https://example.com:8443/",
+		"http://169.254.169.254/computeMetadata/v1/",
+		"http://[::1]/",
+	} {
+		_, err := c.Get(u)
+		assert.Error(t, err, u)
+		assert.True(t, IsRejected(err), u)
+	}
+}
+
+// allowOnly returns a checkIP func that only allows ip.
+func allowOnly(ip string) func(net.IP) error {
+	return func(candidate net.IP) error {
+		if candidate.String() == ip {
+			return nil
+		}
+		return CheckIP(candidate)
+	}
+}
+
+func TestNewClient_AllowedPortAndAddress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello"))
+	}))
+	defer ts.Close()
+
+	c := NewClient(Options{
+		AllowedPorts: []int{portOf(t, ts)},
+		checkIP:      allowOnly("127.0.0.1"),
+	})
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, resp.Body.Close())
+}
+
+func TestNewClient_RefusesRedirectToBlockedAddress(t *testing.T) {
+	// The internal server listens on 127.0.0.2, which stays blocked.
+	internal := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("The internal server should not be reached.")
+	}))
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Can't listen on 127.0.0.2: %s", err)
+	}
+	internal.Listener = l
+	internal.Start()
+	defer internal.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := NewClient(Options{
+		AllowedPorts: []int{portOf(t, ts), portOf(t, internal)},
+		checkIP:      allowOnly("127.0.0.1"),
+	})
+	_, err = c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.True(t, IsRejected(err))
+}
+
+func TestNewClient_RefusesRedirectToBadScheme(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := NewClient(Options{
+		AllowedPorts: []int{portOf(t, ts)},
+		checkIP:      allowOnly("127.0.0.1"),
+	})
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.True(t, IsRejected(err))
+}
@@ -0,0 +1,111 @@
+// scheduler runs jobs periodically within the process, so the application
+// doesn't need an external service like Google Cloud Scheduler.
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jcgregorio/slog"
+)
+
+// Job is a func that is run periodically.
+//
+// A Job never runs concurrently with itself, regardless of whether it was
+// started by a Scheduler or by calling TryRun directly, e.g. from an HTTP
+// handler.
+type Job struct {
+	// Name is used in log messages.
+	Name string
+
+	// Interval is the time between runs. Jobs with an Interval of zero are
+	// never run by the Scheduler, but can still be run via TryRun.
+	Interval time.Duration
+
+	// Timeout, if non-zero, limits how long each run of the Job started by the
+	// Scheduler may take.
+	Timeout time.Duration
+
+	// Run does the work, and should return promptly once ctx is done.
+	Run func(ctx context.Context)
+
+	mutex   sync.Mutex
+	running bool
+}
+
+// TryRun runs the job unless it is already running, in which case it returns
+// false immediately.
+func (j *Job) TryRun(ctx context.Context) bool {
+	j.mutex.Lock()
+	if j.running {
+		j.mutex.Unlock()
+		return false
+	}
+	j.running = true
+	j.mutex.Unlock()
+
+	defer func() {
+		j.mutex.Lock()
+		j.running = false
+		j.mutex.Unlock()
+	}()
+	j.Run(ctx)
+	return true
+}
+
+// Scheduler runs Jobs on their intervals.
+type Scheduler struct {
+	jobs []*Job
+	log  slog.Logger
+}
+
+// New creates a new Scheduler.
+func New(log slog.Logger) *Scheduler {
+	return &Scheduler{
+		log: log,
+	}
+}
+
+// Add a Job to be run. Must be called before Start.
+func (s *Scheduler) Add(job *Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs each Job with a non-zero Interval in its own Go routine, first
+// after one Interval and then every Interval, until ctx is done. Start
+// doesn't block.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		if job.Interval <= 0 {
+			continue
+		}
+		s.log.Infof("Scheduling %q every %s.", job.Name, job.Interval)
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+	s.log.Infof("Running %q.", job.Name)
+	if !job.TryRun(ctx) {
+		s.log.Warningf("Skipped %q, the previous run is still going.", job.Name)
+	}
+}
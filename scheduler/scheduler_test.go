@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTryRun_NoOverlap(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	job := &Job{
+		Name: "slow",
+		Run: func(ctx context.Context) {
+			close(started)
+			<-finish
+		},
+	}
+	done := make(chan bool)
+	go func() {
+		done <- job.TryRun(context.Background())
+	}()
+	<-started
+	assert.False(t, job.TryRun(context.Background()))
+	close(finish)
+	assert.True(t, <-done)
+
+	// Can be run again once the first run is finished.
+	job.Run = func(ctx context.Context) {}
+	assert.True(t, job.TryRun(context.Background()))
+}
+
+func TestScheduler_RunsJobsUntilCancelled(t *testing.T) {
+	var count int32
+	var timedOut int32
+	s := New(logger.New())
+	s.Add(&Job{
+		Name:     "count",
+		Interval: 5 * time.Millisecond,
+		Timeout:  time.Millisecond,
+		Run: func(ctx context.Context) {
+			atomic.AddInt32(&count, 1)
+			<-ctx.Done()
+			atomic.AddInt32(&timedOut, 1)
+		},
+	})
+	s.Add(&Job{
+		Name: "disabled",
+		Run: func(ctx context.Context) {
+			assert.Fail(t, "Jobs with no interval should not run.")
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	n := atomic.LoadInt32(&count)
+	assert.True(t, n >= 2, "Ran %d times.", n)
+	assert.Equal(t, n, atomic.LoadInt32(&timedOut))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&count))
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -20,19 +22,58 @@ import (
 
 	"github.com/jcgregorio/go-lib/admin"
 	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/webmention-run/auth"
+	"github.com/jcgregorio/webmention-run/format"
 	"github.com/jcgregorio/webmention-run/mention"
+	"github.com/jcgregorio/webmention-run/safehttp"
+	"github.com/jcgregorio/webmention-run/scheduler"
 )
 
 // Config keys as found in config.json.
 const (
-	DATASTORE_NAMESPACE = "DATASTORE_NAMESPACE"
-	CLIENT_ID           = "CLIENT_ID"
-	REGION              = "REGION"
-	PROJECT             = "PROJECT"
-	ADMINS              = "ADMINS"
-	HOST                = "HOST"
-	AUTHOR              = "AUTHOR"
-	TARGETS             = "TARGETS"
+	DATASTORE_NAMESPACE  = "DATASTORE_NAMESPACE"
+	CLIENT_ID            = "CLIENT_ID"
+	REGION               = "REGION"
+	PROJECT              = "PROJECT"
+	ADMINS               = "ADMINS"
+	HOST                 = "HOST"
+	AUTHOR               = "AUTHOR"
+	TARGETS              = "TARGETS"
+	STORE                = "STORE"
+	BOLT_FILE            = "BOLT_FILE"
+	POSTGRES_URL         = "POSTGRES_URL"
+	VERIFY_PARALLELISM   = "VERIFY_PARALLELISM"
+	VERIFY_PER_HOST      = "VERIFY_PER_HOST"
+	VERIFY_TIMEOUT       = "VERIFY_TIMEOUT"
+	VERIFY_MAX_ATTEMPTS  = "VERIFY_MAX_ATTEMPTS"
+	VERIFY_BACKOFF       = "VERIFY_BACKOFF"
+	VERIFY_MAX_BACKOFF   = "VERIFY_MAX_BACKOFF"
+	SCHEDULE_VERIFY      = "SCHEDULE.VERIFY"
+	SCHEDULE_REVERIFY    = "SCHEDULE.REVERIFY"
+	SCHEDULE_CLEANUP     = "SCHEDULE.CLEANUP"
+	SCHEDULE_FEEDS       = "SCHEDULE.FEEDS"
+	SCHEDULE_RETRY       = "SCHEDULE.RETRY"
+	CLEANUP_AFTER        = "CLEANUP_AFTER"
+	VERIFY_SECRET        = "VERIFY_SECRET"
+	VERIFY_OIDC_ISSUER   = "VERIFY_OIDC_ISSUER"
+	VERIFY_OIDC_AUDIENCE = "VERIFY_OIDC_AUDIENCE"
+	VERIFY_OIDC_EMAILS   = "VERIFY_OIDC_EMAILS"
+	ALLOWED_PORTS        = "ALLOWED_PORTS"
+	MAX_SOURCE_BYTES     = "MAX_SOURCE_BYTES"
+	SOURCE_CONTENT_TYPES = "SOURCE_CONTENT_TYPES"
+	MAX_PHOTO_BYTES      = "MAX_PHOTO_BYTES"
+	MAX_PHOTO_PIXELS     = "MAX_PHOTO_PIXELS"
+	PHOTO_CONTENT_TYPES  = "PHOTO_CONTENT_TYPES"
+	VERIFY_SYNC_TIMEOUT  = "VERIFY_SYNC_TIMEOUT"
+	FEEDS                = "FEEDS"
+	SEND_MAX_ATTEMPTS    = "SEND_MAX_ATTEMPTS"
+)
+
+// Values for the STORE config key.
+const (
+	DATASTORE_STORE = "datastore"
+	BOLT_STORE      = "bolt"
+	POSTGRES_STORE  = "postgres"
 )
 
 // flags
@@ -48,9 +89,25 @@ var (
 
 	ad *admin.Admin
 
+	// triggerAuth authenticates calls to /VerifyQueuedMentions, /SendFeeds,
+	// and /RetrySent.
+	triggerAuth *auth.Authenticator
+
 	triageTemplate *template.Template
 
+	sentTemplate *template.Template
+
 	mentionsTemplate *template.Template
+
+	facepileTemplate *template.Template
+
+	jobs *scheduler.Scheduler
+
+	verifyJob *scheduler.Job
+
+	feedsJob *scheduler.Job
+
+	retryJob *scheduler.Job
 )
 
 func initialize() {
@@ -71,8 +128,9 @@ func initialize() {
 	log.Infof("%q\n", *resourcesDir)
 
 	ad = admin.New(viper.GetString(CLIENT_ID), viper.GetStringSlice(ADMINS))
+	initTriggerAuth()
 
-	triageTemplate = template.Must(template.New("triage").Funcs(template.FuncMap{
+	adminFuncs := template.FuncMap{
 		"trunc": func(s string) string {
 			if len(s) > 80 {
 				return s[:80] + "..."
@@ -85,7 +143,9 @@ func initialize() {
 			}
 			return " • " + units.HumanDuration(time.Now().Sub(t)) + " ago"
 		},
-	}).Parse(fmt.Sprintf(`<!DOCTYPE html>
+	}
+
+	triageTemplate = template.Must(template.New("triage").Funcs(adminFuncs).Parse(fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head>
     <title></title>
@@ -121,15 +181,28 @@ func initialize() {
 			<option value="good" {{if eq .State "good" }}selected{{ end }} >Good</option>
 			<option value="spam" {{if eq .State "spam" }}selected{{ end }} >Spam</option>
 			<option value="untriaged" {{if eq .State "untriaged" }}selected{{ end }} >Untriaged</option>
+			<option value="deleted" {{if eq .State "deleted" }}selected{{ end }} >Deleted</option>
 		</select>
 		<span>{{ .TS | humanTime }}</span>
 		<div>
 		  <div>Source: <a href="{{ .Source }}">{{ .Source | trunc }}</a></div>
 			<div>Target: <a href="{{ .Target }}">{{ .Target | trunc }}</a></div>
+			{{ if .Type }}
+			<div>Type: {{ .Type }}</div>
+			{{ end }}
+			{{ if .ContentText }}
+			<div>Content: {{ .ContentText | trunc }}</div>
+			{{ end }}
+			{{ if .LastError }}
+			<div>Error: {{ .LastError | trunc }}</div>
+			{{ end }}
+			{{ if .PhotoError }}
+			<div>Photo error: {{ .PhotoError | trunc }}</div>
+			{{ end }}
 		</div>
   {{end}}
   </div>
-	<div><a href="?offset={{.Offset}}">Next</a></div>
+	<div><a href="?offset={{.Offset}}">Next</a> <a href="/Sent">Sent</a></div>
 	<script type="text/javascript" charset="utf-8">
 	 // TODO - listen on div.webmentions for click/input and then write
 	 // triage action back to server.
@@ -153,7 +226,67 @@ func initialize() {
 </body>
 </html>`, viper.GetString(CLIENT_ID))))
 
-	mentionsTemplate = template.Must(template.New("mentions").Funcs(template.FuncMap{
+	sentTemplate = template.Must(template.New("sent").Funcs(adminFuncs).Parse(fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title></title>
+    <meta charset="utf-8" />
+    <meta http-equiv="X-UA-Compatible" content="IE=egde,chrome=1">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="google-signin-scope" content="profile email">
+    <meta name="google-signin-client_id" content="%s">
+    <script src="https://apis.google.com/js/platform.js" async defer></script>
+		<style type="text/css" media="screen">
+		  #sent {
+				display: grid;
+				padding: 1em;
+				grid-template-columns: 5em 10em 1fr;
+				grid-column-gap: 10px;
+				grid-row-gap: 6px;
+			}
+		</style>
+</head>
+<body>
+  <div class="g-signin2" data-onsuccess="onSignIn" data-theme="dark"></div>
+    <script>
+      function onSignIn(googleUser) {
+        document.cookie = "id_token=" + googleUser.getAuthResponse().id_token;
+        if (!{{.IsAdmin}}) {
+          window.location.reload();
+        }
+      };
+    </script>
+  <div id=sent>
+  {{range .Sent }}
+		<span>{{ if .Status }}{{ .Status }}{{ else if .LastError }}Failed{{ else if not .Endpoint }}None{{ end }}</span>
+		<span>{{ .TS | humanTime }}</span>
+		<div>
+		  <div>Source: <a href="{{ .Source }}">{{ .Source | trunc }}</a></div>
+			<div>Target: <a href="{{ .Target }}">{{ .Target | trunc }}</a></div>
+			{{ if .Endpoint }}
+			<div>Endpoint: {{ .Endpoint | trunc }}</div>
+			{{ end }}
+			{{ if .Location }}
+			<div>Location: <a href="{{ .Location }}">{{ .Location | trunc }}</a></div>
+			{{ end }}
+			{{ if .Deleted }}
+			<div>Source deleted</div>
+			{{ end }}
+			<div>Attempts: {{ .Attempts }}</div>
+			{{ if .LastError }}
+			<div>Error: {{ .LastError | trunc }}</div>
+			{{ end }}
+			{{ if not .NextAttempt.IsZero }}
+			<div>Next attempt: {{ .NextAttempt }}</div>
+			{{ end }}
+		</div>
+  {{end}}
+  </div>
+	<div><a href="?offset={{.Offset}}">Next</a> <a href="/">Triage</a></div>
+</body>
+</html>`, viper.GetString(CLIENT_ID))))
+
+	mentionsFuncs := template.FuncMap{
 		"humanTime": func(t time.Time) string {
 			if t.IsZero() {
 				return ""
@@ -172,7 +305,25 @@ func initialize() {
 			}
 			return s
 		},
-	}).Parse(`
+		// ContentHTML is sanitized when the mention is verified, so it can be
+		// included as is.
+		"content": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+		"initial": func(s string) string {
+			for _, r := range strings.TrimSpace(s) {
+				return strings.ToUpper(string(r))
+			}
+			return "?"
+		},
+		// Replies to comments are nested under them, so the comments template
+		// calls itself with thread, which carries the Host along.
+		"thread": func(host string, comments []*format.Comment) FacepileContext {
+			return FacepileContext{Host: host, Comments: comments}
+		},
+	}
+
+	mentionsTemplate = template.Must(template.New("mentions").Funcs(mentionsFuncs).Parse(`
 	<section id=webmention>
 	<h3>WebMentions</h3>
 	{{ $host := .Host }}
@@ -203,16 +354,261 @@ func initialize() {
 					{{ .Source | trunc }}
 				{{ end }}
 			</a>
+			{{ if .ContentHTML }}
+			<div class="wm-reply">{{ .ContentHTML | content }}</div>
+			{{ end }}
+	{{ end }}
+	</section>
+`))
+
+	facepileTemplate = template.Must(template.New("facepile").Funcs(mentionsFuncs).Parse(`
+	{{ define "comments" }}
+	{{ $host := .Host }}
+	{{ range .Comments }}
+		<li class="wm-comment{{ with .Type }} wm-{{ . }}{{ end }}">
+			<span class="wm-author">
+				{{ if .AuthorURL }}
+					{{ if .Thumbnail }}
+					<a href="{{ .AuthorURL}}" rel=nofollow class="wm-thumbnail">
+						<img src="{{ $host }}/Thumbnail/{{ .Thumbnail }}"/>
+					</a>
+					{{ end }}
+					<a href="{{ .AuthorURL}}" rel=nofollow>
+						{{ .Author }}
+					</a>
+				{{ else }}
+					{{ .Author }}
+				{{ end }}
+			</span>
+			<time datetime="{{ .TS | rfc3999 }}">{{ .TS | humanTime }}</time>
+			{{ if .URL }}
+			<a class="wm-content" href="{{ .URL }}" rel=nofollow>
+			{{ else }}
+			<a class="wm-content" href="{{ .Source }}" rel=nofollow>
+			{{ end }}
+			{{ if .Title }}
+				{{ .Title | trunc }}
+			{{ else }}
+				{{ .Source | trunc }}
+			{{ end }}
+			</a>
+			{{ if .ContentHTML }}
+			<div class="wm-reply">{{ .ContentHTML | content }}</div>
+			{{ end }}
+			{{ if .Replies }}
+			<ol class="wm-replies">
+			{{ template "comments" (thread $host .Replies) }}
+			</ol>
+			{{ end }}
+		</li>
+	{{ end }}
+	{{ end }}
+	<section id=webmention class="wm-facepile-mode">
+	<h3>WebMentions</h3>
+	{{ $host := .Host }}
+	{{ range .Facepiles }}
+		<div class="wm-facepile wm-{{ .Plural }}">
+			<span class="wm-count">
+				{{ len .Mentions }} {{ if eq (len .Mentions) 1 }}{{ .Singular }}{{ else }}{{ .Plural }}{{ end }}
+			</span>
+			{{ range .Mentions }}
+				{{ if .AuthorURL }}
+				<a class="wm-face" href="{{ .AuthorURL }}" rel=nofollow title="{{ .Author }}">
+				{{ else if .URL }}
+				<a class="wm-face" href="{{ .URL }}" rel=nofollow title="{{ .Author }}">
+				{{ else }}
+				<a class="wm-face" href="{{ .Source }}" rel=nofollow title="{{ .Author }}">
+				{{ end }}
+					{{ if .Thumbnail }}
+					<img src="{{ $host }}/Thumbnail/{{ .Thumbnail }}" alt="{{ .Author }}"/>
+					{{ else }}
+					<span class="wm-initial">{{ .Author | initial }}</span>
+					{{ end }}
+				</a>
+			{{ end }}
+		</div>
+	{{ end }}
+	{{ if .Comments }}
+	<ol class="wm-comments">
+	{{ template "comments" . }}
+	</ol>
 	{{ end }}
 	</section>
 `))
 
-	m, err = mention.NewMentions(context.Background(), viper.GetString(PROJECT), viper.GetString(DATASTORE_NAMESPACE), log)
+	store, err := newStore()
 	if err != nil {
 		log.Fatal(err)
 	} else {
+		m = mention.NewMentionsFromStore(store, log)
+		if viper.IsSet(VERIFY_PARALLELISM) {
+			m.VerifyParallelism = viper.GetInt(VERIFY_PARALLELISM)
+		}
+		if viper.IsSet(VERIFY_PER_HOST) {
+			m.VerifyPerHost = viper.GetInt(VERIFY_PER_HOST)
+		}
+		if viper.IsSet(VERIFY_MAX_ATTEMPTS) {
+			m.VerifyMaxAttempts = viper.GetInt(VERIFY_MAX_ATTEMPTS)
+		}
+		if viper.IsSet(VERIFY_BACKOFF) {
+			m.VerifyBackoff = viper.GetDuration(VERIFY_BACKOFF)
+		}
+		if viper.IsSet(VERIFY_MAX_BACKOFF) {
+			m.VerifyMaxBackoff = viper.GetDuration(VERIFY_MAX_BACKOFF)
+		}
+		if viper.IsSet(MAX_SOURCE_BYTES) {
+			m.MaxSourceBytes = viper.GetInt64(MAX_SOURCE_BYTES)
+		}
+		if viper.IsSet(SOURCE_CONTENT_TYPES) {
+			m.SourceContentTypes = viper.GetStringSlice(SOURCE_CONTENT_TYPES)
+		}
+		if viper.IsSet(MAX_PHOTO_BYTES) {
+			m.MaxPhotoBytes = viper.GetInt64(MAX_PHOTO_BYTES)
+		}
+		if viper.IsSet(MAX_PHOTO_PIXELS) {
+			m.MaxPhotoPixels = viper.GetInt64(MAX_PHOTO_PIXELS)
+		}
+		if viper.IsSet(PHOTO_CONTENT_TYPES) {
+			m.PhotoContentTypes = viper.GetStringSlice(PHOTO_CONTENT_TYPES)
+		}
+		if viper.IsSet(SEND_MAX_ATTEMPTS) {
+			m.SendMaxAttempts = viper.GetInt(SEND_MAX_ATTEMPTS)
+		}
 		log.Info("Initialized.")
 	}
+
+	initJobs()
+}
+
+// initTriggerAuth sets up authentication for /VerifyQueuedMentions,
+// /SendFeeds, and /RetrySent from the VERIFY_SECRET and VERIFY_OIDC_* config
+// keys.
+func initTriggerAuth() {
+	triggerAuth = &auth.Authenticator{
+		Secret: viper.GetString(VERIFY_SECRET),
+	}
+	if aud := viper.GetString(VERIFY_OIDC_AUDIENCE); aud != "" {
+		issuer := viper.GetString(VERIFY_OIDC_ISSUER)
+		if issuer == "" {
+			issuer = auth.GOOGLE_ISSUER
+		}
+		triggerAuth.OIDC = &auth.OIDC{
+			Issuer:   issuer,
+			Audience: aud,
+			Emails:   viper.GetStringSlice(VERIFY_OIDC_EMAILS),
+			// The issuer is trusted config, not user input, so it doesn't
+			// need newClient's protections, which would refuse an issuer
+			// running locally, e.g. http://localhost:9000.
+			Client: &http.Client{
+				Timeout: time.Second * 30,
+			},
+		}
+	}
+	if triggerAuth.Secret == "" && triggerAuth.OIDC == nil {
+		log.Warningf("Neither %s nor %s is set, all calls to /VerifyQueuedMentions, /SendFeeds, and /RetrySent will be rejected.", VERIFY_SECRET, VERIFY_OIDC_AUDIENCE)
+	}
+}
+
+// initJobs creates the periodic jobs, which are only scheduled in-process if
+// their SCHEDULE interval is set in config.json.
+func initJobs() {
+	viper.SetDefault(CLEANUP_AFTER, 30*24*time.Hour)
+
+	verifyJob = &scheduler.Job{
+		Name:     "verify",
+		Interval: viper.GetDuration(SCHEDULE_VERIFY),
+		Timeout:  viper.GetDuration(VERIFY_TIMEOUT),
+		Run: func(ctx context.Context) {
+			m.VerifyQueuedMentions(ctx, newClient())
+		},
+	}
+	jobs = scheduler.New(log)
+	jobs.Add(verifyJob)
+	jobs.Add(&scheduler.Job{
+		Name:     "reverify",
+		Interval: viper.GetDuration(SCHEDULE_REVERIFY),
+		Run: func(ctx context.Context) {
+			if err := m.QueueReverify(ctx); err != nil {
+				log.Errorf("Failed to queue mentions for re-verification: %s", err)
+			}
+		},
+	})
+	jobs.Add(&scheduler.Job{
+		Name:     "cleanup",
+		Interval: viper.GetDuration(SCHEDULE_CLEANUP),
+		Run: func(ctx context.Context) {
+			if err := m.Cleanup(ctx, viper.GetDuration(CLEANUP_AFTER)); err != nil {
+				log.Errorf("Failed to clean up mentions: %s", err)
+			}
+		},
+	})
+	feedsJob = &scheduler.Job{
+		Name:     "feeds",
+		Interval: viper.GetDuration(SCHEDULE_FEEDS),
+		Run:      pollFeeds,
+	}
+	jobs.Add(feedsJob)
+	retryJob = &scheduler.Job{
+		Name:     "retry",
+		Interval: viper.GetDuration(SCHEDULE_RETRY),
+		Run: func(ctx context.Context) {
+			if err := m.RetrySent(ctx, newClient()); err != nil {
+				log.Errorf("Failed to retry sending webmentions: %s", err)
+			}
+		},
+	}
+	jobs.Add(retryJob)
+}
+
+// pollFeeds sends webmentions for all the new and updated entries in the
+// FEEDS.
+func pollFeeds(ctx context.Context) {
+	c := newClient()
+	for _, feedURL := range viper.GetStringSlice(FEEDS) {
+		reports, err := m.SendFeed(ctx, feedURL, c)
+		if err != nil {
+			log.Errorf("Failed to send webmentions for feed %q: %s", feedURL, err)
+		}
+		for _, report := range reports {
+			log.Infof("Sent %d webmentions for %q from feed %q.", len(report.Results), report.Source, feedURL)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// newClient returns the http.Client used for all outbound requests, which
+// refuses to fetch private or internal addresses.
+func newClient() *http.Client {
+	ports := []int{}
+	for _, s := range viper.GetStringSlice(ALLOWED_PORTS) {
+		port, err := strconv.Atoi(s)
+		if err != nil {
+			log.Errorf("Ignoring invalid port in %s: %q", ALLOWED_PORTS, s)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return safehttp.NewClient(safehttp.Options{
+		Timeout:      time.Second * 30,
+		AllowedPorts: ports,
+		Log:          log,
+	})
+}
+
+// newStore creates the mention.Store selected by the STORE config key.
+func newStore() (mention.Store, error) {
+	switch viper.GetString(STORE) {
+	case "", DATASTORE_STORE:
+		return mention.NewDatastoreStore(context.Background(), viper.GetString(PROJECT), viper.GetString(DATASTORE_NAMESPACE))
+	case BOLT_STORE:
+		return mention.NewBoltStore(viper.GetString(BOLT_FILE))
+	case POSTGRES_STORE:
+		return mention.NewPostgresStore(context.Background(), viper.GetString(POSTGRES_URL))
+	default:
+		return nil, fmt.Errorf("Unknown STORE: %q", viper.GetString(STORE))
+	}
 }
 
 type triageContext struct {
@@ -221,28 +617,37 @@ type triageContext struct {
 	Offset   int64
 }
 
+// paging returns the limit and offset query parameters of a paged request,
+// using defaultLimit if there's no limit.
+func paging(r *http.Request, defaultLimit int64) (int64, int64, error) {
+	limitText := r.FormValue("limit")
+	if limitText == "" {
+		limitText = strconv.FormatInt(defaultLimit, 10)
+	}
+	offsetText := r.FormValue("offset")
+	if offsetText == "" {
+		offsetText = "0"
+	}
+	limit, err := strconv.ParseInt(limitText, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to parse limit: %s", err)
+	}
+	offset, err := strconv.ParseInt(offsetText, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to parse offset: %s", err)
+	}
+	return limit, offset, nil
+}
+
 // triageHandler displays the triage page for Webmentions.
 func triageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	context := &triageContext{}
 	isAdmin := ad.IsAdmin(r, log)
 	if isAdmin {
-		limitText := r.FormValue("limit")
-		if limitText == "" {
-			limitText = "20"
-		}
-		offsetText := r.FormValue("offset")
-		if offsetText == "" {
-			offsetText = "0"
-		}
-		limit, err := strconv.ParseInt(limitText, 10, 32)
+		limit, offset, err := paging(r, 20)
 		if err != nil {
-			log.Infof("Failed to parse limit: %s", err)
-			return
-		}
-		offset, err := strconv.ParseInt(offsetText, 10, 32)
-		if err != nil {
-			log.Infof("Failed to parse offset: %s", err)
+			log.Infof("%s", err)
 			return
 		}
 		context = &triageContext{
@@ -256,6 +661,39 @@ func triageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type sentContext struct {
+	IsAdmin bool
+	Sent    []*mention.WebMentionSent
+	Offset  int64
+}
+
+// sentHandler displays the delivery of the webmentions we've sent.
+func sentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	context := &sentContext{}
+	if ad.IsAdmin(r, log) {
+		limit, offset, err := paging(r, 20)
+		if err != nil {
+			log.Infof("%s", err)
+			return
+		}
+		sent, err := m.SentDeliveries(r.Context(), int(limit), int(offset))
+		if err != nil {
+			log.Errorf("Failed to load sent webmentions: %s", err)
+			http.Error(w, "Failed to load", 500)
+			return
+		}
+		context = &sentContext{
+			IsAdmin: true,
+			Sent:    sent,
+			Offset:  offset + limit,
+		}
+	}
+	if err := sentTemplate.Execute(w, context); err != nil {
+		log.Errorf("Failed to render sent template: %s", err)
+	}
+}
+
 type updateMention struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -285,7 +723,22 @@ type MentionsContext struct {
 	Mentions []*mention.Mention
 }
 
-// mentionsHandler returns HTML describing all the good Webmentions for the given URL.
+// FACEPILE_MODE is the mode parameter of /Mentions that groups likes and
+// reposts into facepiles.
+const FACEPILE_MODE = "facepile"
+
+// FacepileContext is the data for expanding the facepile template, see
+// format.Facepiles.
+type FacepileContext struct {
+	Host      string
+	Facepiles []*format.Facepile
+	Comments  []*format.Comment
+}
+
+// mentionsHandler returns HTML describing all the good Webmentions for the
+// page given in the target query parameter, or if there isn't one, the
+// Referer. If the mode query parameter is FACEPILE_MODE the likes and reposts
+// are shown as facepiles, with the other Webmentions as comments below them.
 func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -294,17 +747,29 @@ func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		return
 	}
-	ref := r.Referer()
-	if len(ref) == 0 {
-		return
+	target := r.FormValue("target")
+	if target == "" {
+		target = r.Referer()
 	}
-	if strings.HasSuffix(ref, "/") {
-		ref = ref[:len(ref)-1]
+	if target == "" {
+		return
 	}
-	mentions := m.GetGood(r.Context(), ref)
+	mentions := m.GetGood(r.Context(), target)
 	if len(mentions) == 0 {
 		return
 	}
+	if r.FormValue("mode") == FACEPILE_MODE {
+		facepiles := format.NewFacepiles(mentions)
+		context := FacepileContext{
+			Host:      viper.GetString(HOST),
+			Facepiles: facepiles.Facepiles,
+			Comments:  facepiles.Comments,
+		}
+		if err := facepileTemplate.Execute(w, context); err != nil {
+			log.Errorf("Failed to expand template: %s", err)
+		}
+		return
+	}
 	context := MentionsContext{
 		Host:     viper.GetString(HOST),
 		Mentions: mentions,
@@ -314,24 +779,289 @@ func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Defaults and limits for the number of mentions in a page of JSON.
+const (
+	DEFAULT_PAGE_SIZE = 100
+	MAX_PAGE_SIZE     = 1000
+)
+
+// mentionsRequest is the query parameters of the endpoints that return
+// mentions as JSON.
+type mentionsRequest struct {
+	// target is the page to return the mentions of, or "" for the mentions of
+	// every target.
+	target string
+
+	// typ, if set, only returns the mentions of that type, e.g. "like".
+	typ string
+
+	// since only returns the mentions received after it.
+	since time.Time
+
+	limit  int64
+	offset int64
+}
+
+// parseMentionsRequest parses the target, since, limit, and offset query
+// parameters.
+func parseMentionsRequest(r *http.Request) (*mentionsRequest, error) {
+	ret := &mentionsRequest{
+		target: mention.Canonicalize(r.FormValue("target")),
+		typ:    r.FormValue("type"),
+	}
+	if s := r.FormValue("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid since, must be RFC 3339: %s", err)
+		}
+		ret.since = since
+	}
+	limit, offset, err := paging(r, DEFAULT_PAGE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || offset < 0 {
+		return nil, fmt.Errorf("Invalid limit or offset.")
+	}
+	if limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+	ret.limit = limit
+	ret.offset = offset
+	return ret, nil
+}
+
+// mentions returns the good mentions of the target, oldest first, or if there
+// is no target the most recent good mentions of all targets, newest first.
+func (q *mentionsRequest) mentions(ctx context.Context) []*mention.Mention {
+	if q.target == "" {
+		return m.GetRecentGood(ctx, q.typ, q.since, int(q.limit), int(q.offset))
+	}
+	return m.GetGoodPage(ctx, q.target, q.typ, q.since, int(q.limit), int(q.offset))
+}
+
+// next returns the URL of the next page from path, given n, the number of
+// mentions in this page, or "" if this is the last page.
+func (q *mentionsRequest) next(path string, n int) string {
+	if int64(n) < q.limit {
+		return ""
+	}
+	next := url.Values{}
+	if q.target != "" {
+		next.Set("target", q.target)
+	}
+	if q.typ != "" {
+		next.Set("type", q.typ)
+	}
+	if !q.since.IsZero() {
+		next.Set("since", q.since.Format(time.RFC3339))
+	}
+	next.Set("limit", strconv.FormatInt(q.limit, 10))
+	next.Set("offset", strconv.FormatInt(q.offset+q.limit, 10))
+	return viper.GetString(HOST) + path + "?" + next.Encode()
+}
+
+// writeJSON writes body as JSON with the given Content-Type, allowing any
+// origin to read it.
+func writeJSON(w http.ResponseWriter, contentType string, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Failed to write JSON: %s", err)
+	}
+}
+
+// corsPreflight sets the CORS headers for the JSON endpoints, returning true
+// if r is a preflight request that has now been handled.
+func corsPreflight(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	return r.Method == "OPTIONS"
+}
+
+// mentionsJSONHandler returns the good webmentions of the target query
+// parameter as JSON, oldest first. The optional since parameter, an RFC 3339
+// time, only returns mentions received after it, and limit and offset page
+// through the results.
+func mentionsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if corsPreflight(w, r) {
+		return
+	}
+	q, err := parseMentionsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.target == "" {
+		http.Error(w, "Missing target", http.StatusBadRequest)
+		return
+	}
+	mentions := q.mentions(r.Context())
+	page := format.NewMentions(q.target, mentions, viper.GetString(HOST))
+	page.Next = q.next("/Mentions.json", len(mentions))
+	writeJSON(w, "application/json", page)
+}
+
+// jf2Handler returns good webmentions as a jf2 feed, taking the same query
+// parameters as mentionsJSONHandler, except that without a target it returns
+// the most recent webmentions of every target.
+func jf2Handler(w http.ResponseWriter, r *http.Request) {
+	if corsPreflight(w, r) {
+		return
+	}
+	q, err := parseMentionsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := "Webmentions"
+	if q.target != "" {
+		name = "Webmentions of " + q.target
+	}
+	writeJSON(w, format.JF2_CONTENT_TYPE, format.NewJF2Feed(name, q.mentions(r.Context()), viper.GetString(HOST)))
+}
+
+// jsonFeedHandler returns good webmentions as a JSON Feed, taking the same
+// query parameters as jf2Handler.
+func jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if corsPreflight(w, r) {
+		return
+	}
+	q, err := parseMentionsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	title := "Webmentions"
+	if q.target != "" {
+		title = "Webmentions of " + q.target
+	}
+	mentions := q.mentions(r.Context())
+	feed := format.NewJSONFeed(title, q.target, viper.GetString(HOST)+r.URL.RequestURI(), mentions, viper.GetString(HOST))
+	feed.NextURL = q.next("/Mentions.jsonfeed", len(mentions))
+	writeJSON(w, format.JSON_FEED_CONTENT_TYPE, feed)
+}
+
+// feedMentions returns the good mentions of all the TARGETS for the Atom and
+// RSS feeds, newest first, along with the feed's title. The optional filter
+// query parameter restricts them to a single domain or URL prefix, and limit
+// to how many are returned.
+func feedMentions(r *http.Request) (string, []*mention.Mention, error) {
+	limit, _, err := paging(r, DEFAULT_PAGE_SIZE)
+	if err != nil {
+		return "", nil, err
+	}
+	if limit <= 0 || limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+	filter := r.FormValue("filter")
+	title := "Webmentions"
+	if filter != "" {
+		title = "Webmentions of " + filter
+	}
+	return title, m.GetRecentGoodOf(r.Context(), viper.GetStringSlice(TARGETS), filter, int(limit)), nil
+}
+
+// writeXML writes body as an XML document with the given Content-Type.
+func writeXML(w http.ResponseWriter, contentType string, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		log.Errorf("Failed to write XML: %s", err)
+		return
+	}
+	if err := xml.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Failed to write XML: %s", err)
+	}
+}
+
+// atomHandler returns an Atom feed of the good webmentions, see feedMentions.
+func atomHandler(w http.ResponseWriter, r *http.Request) {
+	title, mentions, err := feedMentions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	host := viper.GetString(HOST)
+	writeXML(w, format.ATOM_CONTENT_TYPE, format.NewAtom(title, host+r.URL.RequestURI(), mentions, host))
+}
+
+// rssHandler returns an RSS feed of the good webmentions, see feedMentions.
+func rssHandler(w http.ResponseWriter, r *http.Request) {
+	title, mentions, err := feedMentions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeXML(w, format.RSS_CONTENT_TYPE, format.NewRSS(title, mentions, viper.GetString(HOST)))
+}
+
 // incomingWebMentionHandler handles incoming Webmentions.
+//
+// The response has a Location header with the URL of the mention's status,
+// see statusHandler. If VERIFY_SYNC_TIMEOUT is set then the mention is
+// verified immediately, and if that finishes in time the response is a 201,
+// otherwise the mention stays queued and the response is a 202.
 func incomingWebMentionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	mention := mention.New(r.FormValue("source"), r.FormValue("target"))
-	if err := mention.FastValidate(viper.GetStringSlice(TARGETS)); err != nil {
+	incoming := mention.New(r.FormValue("source"), r.FormValue("target"))
+	if err := incoming.FastValidate(viper.GetStringSlice(TARGETS)); err != nil {
 		log.Infof("Invalid request: %s", err)
 		http.Error(w, fmt.Sprintf("Invalid request."), 400)
 		return
 	}
-	if err := m.Put(r.Context(), mention); err != nil {
+	incoming.Target = mention.Canonicalize(incoming.Target)
+	if err := m.Put(r.Context(), incoming); err != nil {
 		log.Infof("Failed to enqueue mention: %s", err)
 		http.Error(w, fmt.Sprintf("Failed to enqueue mention."), 400)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	key := incoming.Key()
+	w.Header().Set("Location", viper.GetString(HOST)+"/Status/"+key)
+	if timeout := viper.GetDuration(VERIFY_SYNC_TIMEOUT); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if err := m.Verify(ctx, key, newClient()); err != nil {
+			log.Infof("Leaving %q queued, failed to verify in time: %s", incoming.Source, err)
+		}
+	}
+	status, err := m.GetStatus(r.Context(), key)
+	if err != nil {
+		log.Errorf("Failed to read back mention: %s", err)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status == mention.QUEUED_STATUS {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Errorf("Failed to write status: %s", err)
+	}
+}
+
+// statusHandler returns the status of a single webmention as JSON, i.e. if it
+// is queued, verified, rejected, or deleted, along with the reason it was
+// rejected.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := m.GetStatus(r.Context(), mux.Vars(r)["key"])
+	if err == mention.ErrNotFound {
+		http.Error(w, "Mention not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to get status: %s", err)
+		http.Error(w, "Failed to get status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Errorf("Failed to write status: %s", err)
+	}
 }
 
 func thumbnailHandler(w http.ResponseWriter, r *http.Request) {
@@ -351,23 +1081,196 @@ func thumbnailHandler(w http.ResponseWriter, r *http.Request) {
 
 // verifyQueuedMentions verifies untriaged webmentions.
 //
-// Should be called on a timer.
+// Should be called on a timer, unless SCHEDULE.VERIFY is set. Verification
+// stops when the request is cancelled, or after VERIFY_TIMEOUT if that is
+// set, and any mentions not verified by then are left for the next call.
+//
+// Callers must authenticate, see initTriggerAuth.
 func verifyQueuedMentions(w http.ResponseWriter, r *http.Request) {
-	client := &http.Client{
-		Timeout: time.Second * 30,
+	if err := triggerAuth.Authenticate(r); err != nil {
+		log.Warningf("Rejected call to /VerifyQueuedMentions: %s", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	if timeout := viper.GetDuration(VERIFY_TIMEOUT); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if !verifyJob.TryRun(ctx) {
+		http.Error(w, "Verification is already running.", http.StatusConflict)
 	}
-	m.VerifyQueuedMentions(client)
+}
+
+// sendFeeds sends webmentions for the new and updated entries in the FEEDS.
+//
+// Should be called on a timer, unless SCHEDULE.FEEDS is set.
+//
+// Callers must authenticate, see initTriggerAuth.
+func sendFeeds(w http.ResponseWriter, r *http.Request) {
+	if err := triggerAuth.Authenticate(r); err != nil {
+		log.Warningf("Rejected call to /SendFeeds: %s", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !feedsJob.TryRun(r.Context()) {
+		http.Error(w, "Sending feeds is already running.", http.StatusConflict)
+	}
+}
+
+// retrySent retries the failed deliveries of webmentions we sent that are due
+// to be retried.
+//
+// Should be called on a timer, unless SCHEDULE.RETRY is set.
+//
+// Callers must authenticate, see initTriggerAuth.
+func retrySent(w http.ResponseWriter, r *http.Request) {
+	if err := triggerAuth.Authenticate(r); err != nil {
+		log.Warningf("Rejected call to /RetrySent: %s", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !retryJob.TryRun(r.Context()) {
+		http.Error(w, "Retrying is already running.", http.StatusConflict)
+	}
+}
+
+// validSource returns an error if source isn't one of our own posts, i.e. on
+// one of the TARGETS domains.
+func validSource(source string) error {
+	u, err := url.Parse(source)
+	if err != nil {
+		return fmt.Errorf("Invalid URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Wrong scheme for source.")
+	}
+	for _, target := range viper.GetStringSlice(TARGETS) {
+		if u.Hostname() == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("Source is not one of the TARGETS.")
+}
+
+// sendHandler sends webmentions for all the links in one of our own posts,
+// given in the source form value. The post isn't sent again if it hasn't
+// changed since it was last sent, unless the force form value is set.
+//
+// Only admins may call it.
+func sendHandler(w http.ResponseWriter, r *http.Request) {
+	if !ad.IsAdmin(r, log) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	source := r.FormValue("source")
+	if err := validSource(source); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := m.Send(r.Context(), source, r.FormValue("force") != "", newClient())
+	if err != nil {
+		log.Infof("Failed to send webmentions for %q: %s", source, err)
+		http.Error(w, fmt.Sprintf("Failed to send webmentions: %s", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorf("Failed to write report: %s", err)
+	}
+}
+
+// sendCommand implements the send subcommand, which sends webmentions for
+// our own posts given on the command line, e.g.
+//
+//	webmention send [--force] https://bitworking.org/news/2019/01/some-post
+//
+// It opens the store itself, so with STORE=bolt the server must be stopped
+// first, since only one process can open the BoltDB file. Use /Send to send
+// from the running server instead.
+func sendCommand(args []string) {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	force := flags.Bool("force", false, "Send even if the post hasn't changed since it was last sent.")
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: webmention send [--force] <url>...")
+	}
+	failed := false
+	for _, source := range flags.Args() {
+		if err := validSource(source); err != nil {
+			log.Errorf("Not sending webmentions for %q: %s", source, err)
+			failed = true
+			continue
+		}
+		report, err := m.Send(context.Background(), source, *force, newClient())
+		if err != nil {
+			log.Errorf("Failed to send webmentions for %q: %s", source, err)
+			failed = true
+			continue
+		}
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		for _, result := range report.Results {
+			if result.LastError != "" {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// canonicalizeCommand implements the canonicalize subcommand, which moves any
+// mentions received before targets were canonicalized to their canonical
+// target, so they are found again, e.g.
+//
+//	webmention canonicalize
+//
+// It only needs to be run once, after upgrading, and should be run while the
+// server is stopped so nothing else is writing mentions.
+func canonicalizeCommand() {
+	n, err := m.CanonicalizeTargets(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to canonicalize targets: %s", err)
+	}
+	fmt.Printf("Canonicalized the targets of %d mentions.\n", n)
 }
 
 func main() {
 	initialize()
+	switch flag.Arg(0) {
+	case "send":
+		sendCommand(flag.Args()[1:])
+		return
+	case "canonicalize":
+		canonicalizeCommand()
+		return
+	}
+	jobs.Start(context.Background())
 
 	r := mux.NewRouter()
 	r.HandleFunc("/Mentions", mentionsHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/Mentions.json", mentionsJSONHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/Mentions.jf2", jf2Handler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/Mentions.jsonfeed", jsonFeedHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/Mentions.atom", atomHandler).Methods("GET")
+	r.HandleFunc("/Mentions.rss", rssHandler).Methods("GET")
 	r.HandleFunc("/IncomingWebMention", incomingWebMentionHandler).Methods("POST")
 	r.HandleFunc("/UpdateMention", updateMentionHandler).Methods("POST")
 	r.HandleFunc("/Thumbnail/{id:[a-z0-9]+}", thumbnailHandler).Methods("GET")
+	r.HandleFunc("/Status/{key:[a-f0-9]{32}}", statusHandler).Methods("GET")
 	r.HandleFunc("/VerifyQueuedMentions", verifyQueuedMentions).Methods("POST")
+	r.HandleFunc("/SendFeeds", sendFeeds).Methods("POST")
+	r.HandleFunc("/RetrySent", retrySent).Methods("POST")
+	r.HandleFunc("/Send", sendHandler).Methods("POST")
+	r.HandleFunc("/Sent", sentHandler).Methods("GET")
 	r.HandleFunc("/", triageHandler).Methods("GET")
 
 	http.Handle("/", r)